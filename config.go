@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of a -config file. Keys are named after the
+// flags they stand in for, and a flag given on the command line overrides
+// its key.
+type fileConfig struct {
+	Sources []string `yaml:"sources"` // URLs, in place of a -sources file
+
+	Workers         *int   `yaml:"workers"`
+	ScrapeTimeout   string `yaml:"scrape-timeout"`
+	ValidateTimeout string `yaml:"validate-timeout"`
+
+	Output string `yaml:"output"`
+	Format string `yaml:"format"`
+
+	Protocol     []string `yaml:"protocol"`
+	Country      []string `yaml:"country"`
+	MinAnonymity string   `yaml:"min-anonymity"`
+
+	CheckURL  string   `yaml:"check-url"`
+	CheckURLs []string `yaml:"check-urls"`
+}
+
+// loadConfig reads a YAML config file, rejecting unknown keys.
+func loadConfig(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var bad []string
+	for i, source := range cfg.Sources {
+		normalized, err := normalizeSource(source)
+		if err != nil {
+			bad = append(bad, strconv.Quote(source))
+			continue
+		}
+		cfg.Sources[i] = normalized
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("%s: unusable source URLs (want http or https URLs): %s", path, strings.Join(bad, ", "))
+	}
+	return &cfg, nil
+}
+
+// flagValues returns the config's settings as flag values, keyed by flag
+// name, for the keys the file sets.
+func (c *fileConfig) flagValues() map[string]string {
+	values := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	if c.Workers != nil {
+		values["workers"] = strconv.Itoa(*c.Workers)
+	}
+	set("scrape-timeout", c.ScrapeTimeout)
+	set("validate-timeout", c.ValidateTimeout)
+	set("output", c.Output)
+	set("format", c.Format)
+	set("protocol", strings.Join(c.Protocol, ","))
+	set("country", strings.Join(c.Country, ","))
+	set("min-anonymity", c.MinAnonymity)
+	set("check-url", c.CheckURL)
+	set("check-urls", strings.Join(c.CheckURLs, ","))
+	return values
+}
+
+// apply sets every flag the config covers that wasn't given on the command
+// line, so flags parse and validate config values exactly as their own.
+func (c *fileConfig) apply() error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range c.flagValues() {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("config key %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -1,256 +1,1146 @@
 package main
 
 import (
-	"encoding/base64"
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"regexp"
-	"strconv"
+	"slices"
 	"strings"
-	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
+	"proxyScrape/proxyscrape"
 )
 
-// List of proxy sites
-var proxySites = []string{
-	"https://free-proxy-list.net/",
-	"https://www.sslproxies.org/",
-	"https://www.us-proxy.org/",
-	"https://www.socks-proxy.net/",
-	"https://www.proxynova.com/proxy-server-list/",
-	"https://hidemy.name/en/proxy-list/",
-	"https://spys.one/en/free-proxy-list/",
-	"https://www.proxy-list.download/HTTP",
-	"https://www.proxy-list.download/SOCKS5",
-	"https://proxylist.geonode.com/free-proxy-list",
-	"https://www.openproxy.space/list/",
-	"https://proxydb.net/",
-	"https://www.proxyscrape.com",
+func saveProxies(filename, format string, annotate bool, proxies []proxyscrape.Proxy) error {
+	err := writeFileAtomic(filename, func(w io.Writer) error {
+		return writeProxies(w, format, annotate, proxies)
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("saved proxies", "count", len(proxies), "file", filename)
+	return nil
 }
 
-type ProxyPool struct {
-	mu      sync.RWMutex
-	proxies []string
-	current int
+// writeProxies writes proxies to w in format, or annotated txt lines if
+// annotate is set.
+func writeProxies(w io.Writer, format string, annotate bool, proxies []proxyscrape.Proxy) error {
+	if annotate {
+		return proxyscrape.WriteAnnotated(w, proxies)
+	}
+	return proxyscrape.WriteProxies(w, format, proxies)
 }
 
-func (p *ProxyPool) Add(proxy string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.proxies = append(p.proxies, proxy)
-}
+// writeFileAtomic writes filename via a temporary file in the same
+// directory that is renamed into place once complete, so readers see
+// either the old file or the whole new one, never a partial write. Missing
+// parent directories are created.
+func writeFileAtomic(filename string, write func(io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
 
-func (p *ProxyPool) GetNext() (string, bool) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-	if len(p.proxies) == 0 {
-		return "", false
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
 	}
-	p.current = (p.current + 1) % len(p.proxies)
-	return p.proxies[p.current], true
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
 }
 
-func scrapeProxies(url string, wg *sync.WaitGroup, proxyChan chan<- string) {
-	defer wg.Done()
+// splitProtocols always get a file from saveByProtocol, even when empty, so
+// a protocol that found nothing doesn't leave a stale file from an earlier
+// run behind.
+var splitProtocols = []string{"http", "socks4", "socks5"}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:       10,
-			IdleConnTimeout:    30 * time.Second,
-			DisableCompression: true,
-			DisableKeepAlives:  true,
-		},
+// saveByProtocol saves proxies to one file per protocol in dir, named after
+// the protocol and format (http.txt, socks5.txt, ...), plus a manifest.json
+// listing each file and its count.
+func saveByProtocol(dir, format string, annotate bool, proxies []proxyscrape.Proxy) error {
+	groups := make(map[string][]proxyscrape.Proxy)
+	for _, protocol := range splitProtocols {
+		groups[protocol] = nil
+	}
+	for _, p := range proxies {
+		protocol := proxyProtocol(p)
+		groups[protocol] = append(groups[protocol], p)
+	}
+
+	type manifestEntry struct {
+		Protocol string `json:"protocol"`
+		File     string `json:"file"`
+		Count    int    `json:"count"`
+	}
+	manifest := []manifestEntry{}
+	for _, protocol := range slices.Sorted(maps.Keys(groups)) {
+		name := protocol + "." + format
+		if err := saveProxies(filepath.Join(dir, name), format, annotate, groups[protocol]); err != nil {
+			return err
+		}
+		manifest = append(manifest, manifestEntry{Protocol: protocol, File: name, Count: len(groups[protocol])})
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	data, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
-		fmt.Printf("Error creating request: %v\n", err)
-		return
+		return err
 	}
+	return writeFileAtomic(filepath.Join(dir, "manifest.json"), func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+// defaultOutputPath is where proxies are saved when -output isn't given:
+// ~/.proxychains/proxies, or a "proxies" file in the working directory or
+// failing that the temp directory when there's no usable home directory,
+// as in minimal containers and CI.
+func defaultOutputPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err == nil {
+		return filepath.Join(homeDir, ".proxychains", "proxies"), nil
+	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("Error fetching %s: %v\n", url, err)
-		return
+	var candidates []string
+	if wd, err := os.Getwd(); err == nil {
+		candidates = append(candidates, wd)
+	}
+	candidates = append(candidates, os.TempDir())
+	for _, dir := range candidates {
+		if writableDir(dir) {
+			path := filepath.Join(dir, "proxies")
+			slog.Warn("no home directory, saving to fallback path", "error", err, "file", path)
+			return path, nil
+		}
 	}
-	defer resp.Body.Close()
+	return "", fmt.Errorf("no home directory (%w) and no writable fallback directory", err)
+}
+
+// isFIFO reports whether path is a named pipe.
+func isFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+// writableDir reports whether a file can be created in dir.
+func writableDir(dir string) bool {
+	f, err := os.CreateTemp(dir, ".proxyscrape-*")
 	if err != nil {
-		return
+		return false
 	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
 
-	// Try table scraping first
-	doc.Find("table tbody tr").Each(func(i int, row *goquery.Selection) {
-		ip := row.Find("td").Eq(0).Text()
-		port := row.Find("td").Eq(1).Text()
-		protocol := row.Find("td").Eq(4).Text()
-
-		if ip != "" && port != "" {
-			proxy := fmt.Sprintf("%s:%s", ip, port)
-			if strings.Contains(strings.ToLower(protocol), "socks5") {
-				proxyChan <- "socks5://" + proxy
-			} else {
-				proxyChan <- "http://" + proxy
-			}
+// readLines returns the non-blank lines of path, or stdin if path is "-",
+// skipping # comments, along with their 1-based line numbers.
+func readLines(path string) (lines []string, lineNos []int, err error) {
+	file := os.Stdin
+	if path != "-" {
+		if file, err = os.Open(path); err != nil {
+			return nil, nil, err
 		}
-	})
+		defer file.Close()
+	}
 
-	// Try JavaScript deobfuscation
-	doc.Find("script").Each(func(_ int, s *goquery.Selection) {
-		js := s.Text()
-		if strings.Contains(js, "document.write") {
-			ip := deobfuscateIP(js)
-			if ip != "" {
-				proxyChan <- "http://" + ip
-			}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-	})
+		lines = append(lines, line)
+		lineNos = append(lineNos, lineNo)
+	}
+	return lines, lineNos, scanner.Err()
 }
 
-func deobfuscateIP(js string) string {
-	if strings.Contains(js, "atob") {
-		re := regexp.MustCompile(`atob\("([^"]+)"\)`)
-		if match := re.FindStringSubmatch(js); len(match) > 1 {
-			decoded, _ := base64.StdEncoding.DecodeString(match[1])
-			return string(decoded)
+// loadSources reads proxy site URLs from path, one per line, normalized by
+// normalizeSource. Blank lines and lines starting with # are ignored. Any
+// unusable URL is an error listing all of them, so a typo fails the run
+// before any scraping starts.
+func loadSources(path string) ([]string, error) {
+	lines, lineNos, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources, bad []string
+	for i, line := range lines {
+		source, err := normalizeSource(line)
+		if err != nil {
+			bad = append(bad, fmt.Sprintf("line %d %q", lineNos[i], line))
+			continue
 		}
+		sources = append(sources, source)
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("%s: unusable source URLs (want http or https URLs): %s", path, strings.Join(bad, ", "))
 	}
+	return sources, nil
+}
 
-	js = strings.ReplaceAll(js, "document.write", "")
-	js = strings.ReplaceAll(js, "repeat", "")
-	js = strings.ReplaceAll(js, "substring", "")
-	js = strings.ReplaceAll(js, "concat", "")
-	re := regexp.MustCompile(`[\d.]+`)
-	return strings.Join(re.FindAllString(js, -1), "")
+// normalizeSource returns source as an absolute http or https URL, adding
+// https:// to a bare host like "free-proxy-list.net" or a
+// protocol-relative "//host/path".
+func normalizeSource(source string) (string, error) {
+	switch {
+	case strings.HasPrefix(source, "//"):
+		source = "https:" + source
+	case !strings.Contains(source, "://"):
+		source = "https://" + source
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("malformed source URL %q", source)
+	}
+	return source, nil
 }
-func isValidIP(ip string) bool {
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return false
+
+// canonicalSource lowercases the scheme and host of a source URL, drops any
+// fragment and gives an empty path a trailing slash, so the same site
+// written two ways is scraped once.
+func canonicalSource(source string) string {
+	u, err := url.Parse(source)
+	if err != nil {
+		return source
 	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
 
-	for _, part := range parts {
-		num, err := strconv.Atoi(part)
-		if err != nil || num < 0 || num > 255 {
-			return false
+// dedupSources canonicalizes sources and drops repeats, keeping the first.
+// A trailing slash on the path doesn't make two sources distinct.
+func dedupSources(sources []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, source := range sources {
+		canonical := canonicalSource(source)
+		key := canonical
+		if u, err := url.Parse(canonical); err == nil && u.Path != "/" {
+			u.Path = strings.TrimSuffix(u.Path, "/")
+			key = u.String()
 		}
+		if seen[key] {
+			slog.Info("dropping duplicate source", "source", source)
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, canonical)
 	}
-	return true
+	return unique
 }
-func validateProxy(proxy string) bool {
-	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(proxy, "http://"), "socks5://"), ":")
-	if len(parts) != 2 {
-		return false
-	}
 
-	ip, port := parts[0], parts[1]
-	if !isValidIP(ip) {
+// sourceMatches reports whether name, as given to -disable-source, refers
+// to source: a registered name or URL given in full, or the URL's hostname,
+// with or without its "www.".
+func sourceMatches(source, name string) bool {
+	name = strings.ToLower(name)
+	if strings.EqualFold(source, name) || canonicalSource(source) == canonicalSource(name) {
+		return true
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
 		return false
 	}
+	host := strings.ToLower(u.Hostname())
+	return host == name || strings.TrimPrefix(host, "www.") == strings.TrimPrefix(name, "www.")
+}
 
-	portNum, err := strconv.Atoi(port)
-	if err != nil || portNum < 1 || portNum > 65535 {
+// disableSources drops the sources and registered sources any of names
+// refers to, warning about names that match neither.
+func disableSources(names, sources, registered []string) ([]string, []string) {
+	matched := make(map[string]bool)
+	drop := func(source string) bool {
+		for _, name := range names {
+			if sourceMatches(source, name) {
+				matched[name] = true
+				return true
+			}
+		}
 		return false
 	}
+	sources = slices.DeleteFunc(slices.Clone(sources), drop)
+	registered = slices.DeleteFunc(slices.Clone(registered), drop)
+	for _, name := range names {
+		if !matched[name] {
+			slog.Warn("-disable-source matches no source", "name", name)
+		}
+	}
+	return sources, registered
+}
 
-	proxyURL, err := url.Parse(proxy)
+// printTop writes the n most reliable proxies in store to stdout.
+func printTop(store *proxyscrape.Store, n int) error {
+	top, err := store.Top(context.Background(), n)
 	if err != nil {
-		return false
+		return err
 	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROXY\tSUCCESS\tCHECKS\tLAST LATENCY\tLAST SEEN")
+	for _, p := range top {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", p.Proxy, p.SuccessCount, p.TotalChecks, p.LastLatency, p.LastSeen.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyURL(proxyURL),
-		},
-		Timeout: 7 * time.Second,
+// listSources prints every built-in and registered source with the parser
+// its pages are read with and whether a run uses it by default, that is
+// unless disabled names it.
+func listSources(w io.Writer, disabled []string) error {
+	enabled := func(source string) string {
+		if slices.ContainsFunc(disabled, func(name string) bool { return sourceMatches(source, name) }) {
+			return "no"
+		}
+		return "yes"
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tPARSER\tDEFAULT")
+	for _, source := range proxyscrape.DefaultSources {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", source, proxyscrape.ParserName(source), enabled(source))
 	}
+	for _, name := range proxyscrape.RegisteredSources() {
+		if !slices.Contains(proxyscrape.DefaultSources, name) {
+			fmt.Fprintf(tw, "%s\tcustom\t%s\n", name, enabled(name))
+		}
+	}
+	return tw.Flush()
+}
 
-	resp, err := client.Get("http://api.ipify.org")
-	if err != nil {
-		fmt.Printf("Dead: %s (error: %v)\n", proxy, err)
-		return false
+// setupLogging installs the default slog logger on stderr. Handlers write
+// each record with a single call, so concurrent workers never interleave
+// within a line.
+func setupLogging(level, format string, quiet bool) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid -log-level %q", level)
+	}
+	if quiet {
+		lvl = max(lvl, slog.LevelWarn)
 	}
-	defer resp.Body.Close()
+	handlerOpts := &slog.HandlerOptions{Level: lvl}
 
-	fmt.Printf("Alive: %s\n", proxy)
-	return resp.StatusCode == 200
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	default:
+		return fmt.Errorf("invalid -log-format %q (want text or json)", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
 }
 
-func saveProxies(filename string, proxies []string) error {
-	file, err := os.Create(filename)
+// loadProxies reads proxies from path, one per line in the txt output
+// format, and labels them with source. Unparseable lines are skipped with a
+// warning.
+func loadProxies(path, source string) ([]proxyscrape.Proxy, error) {
+	lines, lineNos, err := readLines(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
 
-	for _, proxy := range proxies {
-		file.WriteString(proxy + "\n")
+	var proxies []proxyscrape.Proxy
+	for i, line := range lines {
+		line, _, _ = strings.Cut(line, " #")
+		p, err := proxyscrape.ParseProxy(line)
+		if err != nil {
+			slog.Warn("skipping malformed proxy", "file", path, "line", lineNos[i], "error", err)
+			continue
+		}
+		p.Source = source
+		proxies = append(proxies, p)
 	}
-	fmt.Printf("Saved %d proxies to %s\n", len(proxies), filename)
-	return nil
+	return proxies, nil
 }
 
 func main() {
-	pool := &ProxyPool{proxies: make([]string, 0)}
-	var wg sync.WaitGroup
-	proxyChan := make(chan string, 1000)
-	validChan := make(chan string, 1000)
-
-	// Start proxy scrapers
-	for _, site := range proxySites {
-		wg.Add(1)
-		go scrapeProxies(site, &wg, proxyChan)
-	}
-
-	// Start validator workers
-	const numWorkers = 20
-	var validatorWg sync.WaitGroup
-
-	for i := 0; i < numWorkers; i++ {
-		validatorWg.Add(1)
-		go func() {
-			defer validatorWg.Done()
-			for proxy := range proxyChan {
-				if validateProxy(proxy) {
-					validChan <- proxy
-				}
+	format := flag.String("format", "txt", "output format: "+strings.Join(proxyscrape.OutputFormats, ", "))
+	output := flag.String("output", "", "file the proxies are saved to, the directory with -split-by, or - or a named pipe to stream them to as they're found (default ~/.proxychains/proxies)")
+	annotate := flag.Bool("annotate", false, "follow each line of -format txt with a comment of the proxy's country, latency and anonymity")
+	splitBy := flag.String("split-by", "", "save to one file per group instead of a single file; only \"protocol\" is supported")
+	var opts proxyscrape.ValidateOptions
+	flag.StringVar(&opts.CheckURL, "check-url", proxyscrape.DefaultCheckURL, "URL fetched through each proxy to validate it")
+	checkURLs := flag.String("check-urls", "", "comma-separated alternatives to -check-url, rotated across checks and tried when one fails")
+	flag.IntVar(&opts.ExpectStatus, "expect-status", http.StatusOK, "HTTP status the check URL must return")
+	flag.StringVar(&opts.Expect, "expect", "", "substring the check response body must contain")
+	flag.StringVar(&opts.ProbeMethod, "probe-method", http.MethodGet, "method of the check request: GET, or the lighter HEAD when no body is needed")
+	flag.IntVar(&opts.Attempts, "attempts", proxyscrape.DefaultCheckAttempts, "times a failing check is retried before a proxy is declared dead")
+	flag.BoolVar(&opts.Warmup, "warmup", false, "send each proxy a warm-up request before checking it, for proxies that fail only their first request")
+	flag.IntVar(&opts.Confirmations, "confirm", 1, "extra checks a proxy must pass after the first before it counts as alive")
+	flag.StringVar(&opts.Judge, "judge", proxyscrape.DefaultJudge, "header-echo endpoint used for anonymity detection")
+	flag.BoolVar(&opts.DropTransparent, "drop-transparent", false, "drop proxies the judge sees our real IP through, whether passed on in a header or by not proxying at all")
+	minAnonymityFlag := flag.String("min-anonymity", "", "detect anonymity and drop proxies below this level: transparent, anonymous, elite")
+	excludeCIDR := flag.String("exclude-cidr", "", "comma-separated CIDR ranges whose proxies are skipped, e.g. 10.0.0.0/8")
+	includeCIDR := flag.String("include-cidr", "", "comma-separated CIDR ranges; if set, only proxies inside them are validated")
+	ipv4Only := flag.Bool("ipv4-only", false, "keep only IPv4 proxies")
+	ipv6Only := flag.Bool("ipv6-only", false, "keep only IPv6 proxies")
+	countryFlag := flag.String("country", "", "comma-separated ISO country codes to keep, e.g. US,DE")
+	flag.IntVar(&opts.Workers, "workers", proxyscrape.DefaultWorkers, "number of concurrent validator workers")
+	flag.IntVar(&opts.MaxWorkers, "max-workers", 0, "adapt the number of validator workers to the timeout rate, up to this many, instead of using -workers")
+	flag.IntVar(&opts.MinWorkers, "min-workers", 0, "fewest validator workers with -max-workers, and the number it starts at (default a quarter of -max-workers)")
+	noValidate := flag.Bool("no-validate", false, "save every scraped candidate without validating it")
+	sortMode := flag.String("sort", "latency", "order of saved proxies: "+strings.Join(sortModes, ", "))
+	shuffleOutput := flag.Bool("shuffle-output", false, "save proxies in random order, reproducible with -random-seed, so consumers taking the first line spread their load")
+	maxLatency := flag.Duration("max-latency", 0, "drop proxies slower than this round-trip, e.g. 500ms (0 keeps all)")
+	limit := flag.Int("limit", 0, "stop scraping once this many unique candidates are queued (0 means no limit)")
+	minAlive := flag.Int("min-alive", 0, "exit non-zero, after saving, if a run keeps fewer than this many proxies")
+	maxSourceFailures := flag.Int("max-source-failures", -1, "abort a run without saving once scraping ends if more than this many sources failed (negative means no limit)")
+	minAliveRatio := flag.Float64("min-alive-ratio", 0, "exit non-zero, after saving, if less than this fraction of validated proxies is alive, e.g. 0.05")
+	var scrapeOpts proxyscrape.ScrapeOptions
+	flag.DurationVar(&scrapeOpts.Timeout, "scrape-timeout", proxyscrape.DefaultScrapeTimeout, "timeout for fetching each proxy site")
+	flag.IntVar(&scrapeOpts.Retries, "scrape-retries", proxyscrape.DefaultScrapeRetries, "attempts per proxy site before giving up on transient errors")
+	flag.DurationVar(&scrapeOpts.RetryBackoff, "retry-backoff", proxyscrape.DefaultRetryBackoff, "initial delay between scrape retries, doubled each attempt")
+	flag.IntVar(&scrapeOpts.MaxPages, "max-pages", proxyscrape.DefaultMaxPages, "maximum pages fetched from each paginated proxy site")
+	flag.IntVar(&scrapeOpts.MaxPerSource, "max-per-source", 0, "take at most this many candidates from each proxy site (0 means no limit)")
+	flag.DurationVar(&opts.Timeout, "validate-timeout", proxyscrape.DefaultValidateTimeout, "timeout for each proxy validation request")
+	flag.StringVar(&scrapeOpts.CacheDir, "cache-dir", "", "save the raw body of every fetched proxy site page in this directory")
+	flag.BoolVar(&scrapeOpts.FromCache, "from-cache", false, "parse pages saved by -cache-dir instead of fetching them, without touching the network for scraping")
+	flag.DurationVar(&scrapeOpts.ProxyscrapeAPI.Timeout, "proxyscrape-timeout", 0, "ask the proxyscrape.com API only for proxies it saw answer within this long, e.g. 5s (default per source URL)")
+	flag.StringVar(&scrapeOpts.ProxyscrapeAPI.Country, "proxyscrape-country", "", "ask the proxyscrape.com API only for proxies in this ISO country, or all (default per source URL)")
+	flag.StringVar(&scrapeOpts.ProxyscrapeAPI.Key, "proxyscrape-key", os.Getenv("PROXYSCRAPE_API_KEY"), "API key for the proxyscrape.com API, for paid plans (default $PROXYSCRAPE_API_KEY)")
+	respectRobots := flag.Bool("respect-robots", false, "skip proxy site pages disallowed by their host's robots.txt")
+	hostDelay := flag.Duration("host-delay", proxyscrape.DefaultHostDelay, "minimum delay between requests to the same host (0 disables)")
+	maxRuntime := flag.Duration("max-runtime", 0, "stop after this long, saving whatever has been validated (0 means no limit)")
+	interval := flag.Duration("interval", 0, "keep running, re-scraping and re-validating on this interval (e.g. 15m)")
+	scrapeConcurrency := flag.Int("scrape-concurrency", 8, "maximum number of proxy sites scraped at once")
+	startJitter := flag.Duration("start-jitter", 2*time.Second, "start each scraper after a random delay up to this long (0 disables)")
+	sourcesFile := flag.String("sources", "", "file of proxy site URLs, one per line, replacing the built-in list")
+	deadTTL := flag.Duration("dead-ttl", 0, "skip proxies that failed validation within this long in an earlier run, e.g. 6h (0 disables)")
+	deadFile := flag.String("dead-file", "", "file recording recently dead proxies for -dead-ttl (default next to -output)")
+	seedFile := flag.String("seed", "", "file of previously saved proxies to re-check alongside scraped ones")
+	validateOnly := flag.String("validate-only", "", "skip scraping: validate the proxies in this file (- for stdin) and write live ones to stdout")
+	disableSource := flag.String("disable-source", "", "comma-separated hostnames, URLs or registered names of sources to leave out, e.g. spys.one")
+	appendSources := flag.Bool("append-sources", false, "merge -sources with the built-in list instead of replacing it")
+	flag.BoolVar(&opts.CheckHTTPS, "check-https", false, "also test whether each proxy can tunnel HTTPS")
+	flag.BoolVar(&opts.RequireHTTPS, "require-https", false, "drop proxies that can't tunnel HTTPS (implies -check-https)")
+	flag.StringVar(&opts.HTTPSCheckURL, "https-check-url", proxyscrape.DefaultHTTPSCheckURL, "https URL fetched through each proxy for the HTTPS check")
+	flag.StringVar(&opts.TargetURL, "target", "", "also fetch this URL, the site you need the proxies for, through each proxy and drop those that can't reach it")
+	flag.IntVar(&opts.TargetStatus, "target-status", http.StatusOK, "HTTP status -target must return")
+	resolverFlag := flag.String("resolver", "", "resolve hosts for validation with this DNS server (host[:port]) or DNS-over-HTTPS URL instead of the system resolver")
+	flag.BoolVar(&opts.InsecureSkipVerify, "insecure-skip-verify", false, "UNSAFE: don't verify TLS certificates of check and judge URLs (for self-signed endpoints only)")
+	protocolFlag := flag.String("protocol", "", "comma-separated protocols to keep, e.g. socks5,http (default all)")
+	upstreamProxy := flag.String("upstream-proxy", "", "fetch proxy sites through this proxy, e.g. socks5://127.0.0.1:9050 (validation stays direct)")
+	challengeProxy := flag.String("challenge-proxy", "", "fetch proxy sites that answer with an anti-bot challenge page again through this proxy, e.g. one that solves them")
+	randomSeed := flag.Uint64("random-seed", 0, "seed for User-Agent choice, source order, jitter and the pool servers' random picks, to reproduce a run (default time-based)")
+	userAgent := flag.String("user-agent", "", "send this User-Agent on every scrape request instead of rotating")
+	userAgentsFile := flag.String("user-agents", "", "file of User-Agents to rotate through, one per line")
+	serveAddr := flag.String("serve", "", "serve the live proxy pool over HTTP on this address, e.g. :8080")
+	rotateAddr := flag.String("rotate", "", "run a rotating forward proxy over the live pool on this address, e.g. :9000")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address, e.g. :9100")
+	dbPath := flag.String("db", "", "SQLite database recording proxy history across runs")
+	listSourcesFlag := flag.Bool("list-sources", false, "print the built-in sources with the parser each is read with and exit")
+	dbTop := flag.Int("db-top", 0, "print the N most reliable proxies from -db and exit")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn, error")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	quiet := flag.Bool("quiet", false, "log only warnings and errors, and print just a final summary to stdout")
+	showProgress := flag.Bool("progress", false, "print a live progress line with scraped, validated and live counts to stdout")
+	jsonSummary := flag.String("json-summary", "", "after each run, write a JSON object of run statistics to this file (- for stdout)")
+	configFile := flag.String("config", "", "YAML file of settings; flags given on the command line override it")
+	flag.Parse()
+
+	var cfg *fileConfig
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err == nil {
+			err = cfg.apply()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if err := setupLogging(*logLevel, *logFormat, *quiet); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var disabled []string
+	for _, name := range strings.Split(*disableSource, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			disabled = append(disabled, name)
+		}
+	}
+	if *listSourcesFlag {
+		if err := listSources(os.Stdout, disabled); err != nil {
+			slog.Error("listing sources", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !slices.Contains(proxyscrape.OutputFormats, *format) {
+		fmt.Fprintf(os.Stderr, "unknown output format %q (want one of: %s)\n", *format, strings.Join(proxyscrape.OutputFormats, ", "))
+		os.Exit(2)
+	}
+	if *annotate && *format != "txt" {
+		fmt.Fprintf(os.Stderr, "-annotate only applies to -format txt, not %s\n", *format)
+		os.Exit(2)
+	}
+	if *splitBy != "" && *splitBy != "protocol" {
+		fmt.Fprintf(os.Stderr, "unknown -split-by %q (want protocol)\n", *splitBy)
+		os.Exit(2)
+	}
+	sortGiven := false
+	flag.Visit(func(f *flag.Flag) { sortGiven = sortGiven || f.Name == "sort" })
+	stream := *output == "-" || isFIFO(*output)
+	if stream {
+		what := "-output -"
+		if *output != "-" {
+			what = "-output to a named pipe"
+		}
+		var conflict string
+		switch {
+		case *splitBy != "":
+			conflict = "-split-by"
+		case sortGiven && *sortMode != "none":
+			conflict = "-sort"
+		case *shuffleOutput:
+			conflict = "-shuffle-output"
+		case *output == "-" && *showProgress:
+			conflict = "-progress"
+		case *output == "-" && *jsonSummary == "-":
+			conflict = "-json-summary -"
+		case *output == "-" && *deadTTL > 0 && *deadFile == "":
+			conflict = "-dead-ttl without -dead-file"
+		}
+		if conflict != "" {
+			fmt.Fprintf(os.Stderr, "%s streams proxies as they're found and can't be combined with %s\n", what, conflict)
+			os.Exit(2)
+		}
+		if !slices.Contains(proxyscrape.StreamFormats, *format) && *format != "env" {
+			fmt.Fprintf(os.Stderr, "%s can't stream -format %s (want one of: %s, or env)\n", what, *format, strings.Join(proxyscrape.StreamFormats, ", "))
+			os.Exit(2)
+		}
+		*sortMode = "none"
+		// a reader closing the pipe early should be an error to log, not
+		// the end of the program
+		signal.Ignore(syscall.SIGPIPE)
+	}
+	if *output == "" {
+		path, err := defaultOutputPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't determine the default output file: %v; use -output\n", err)
+			os.Exit(2)
+		}
+		if *splitBy != "" {
+			path = filepath.Dir(path)
+		}
+		*output = path
+	}
+	for _, u := range strings.Split(*checkURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			opts.CheckURLs = append(opts.CheckURLs, u)
+		}
+	}
+	opts.ProbeMethod = strings.ToUpper(opts.ProbeMethod)
+	if opts.ProbeMethod != http.MethodGet && opts.ProbeMethod != http.MethodHead {
+		fmt.Fprintf(os.Stderr, "unknown -probe-method %q (want GET or HEAD)\n", opts.ProbeMethod)
+		os.Exit(2)
+	}
+	if opts.Attempts < 1 {
+		fmt.Fprintf(os.Stderr, "-attempts must be at least 1, got %d\n", opts.Attempts)
+		os.Exit(2)
+	}
+	if opts.Confirmations < 0 {
+		fmt.Fprintf(os.Stderr, "-confirm must not be negative, got %d\n", opts.Confirmations)
+		os.Exit(2)
+	}
+	if opts.Workers < 1 {
+		fmt.Fprintf(os.Stderr, "-workers must be at least 1, got %d\n", opts.Workers)
+		os.Exit(2)
+	}
+	switch {
+	case opts.MaxWorkers < 0 || opts.MinWorkers < 0:
+		fmt.Fprintln(os.Stderr, "-min-workers and -max-workers must not be negative")
+		os.Exit(2)
+	case opts.MinWorkers > 0 && opts.MaxWorkers == 0:
+		fmt.Fprintln(os.Stderr, "-min-workers requires -max-workers")
+		os.Exit(2)
+	case opts.MinWorkers > opts.MaxWorkers:
+		fmt.Fprintf(os.Stderr, "-min-workers (%d) must not exceed -max-workers (%d)\n", opts.MinWorkers, opts.MaxWorkers)
+		os.Exit(2)
+	}
+	if scrapeOpts.Timeout <= 0 {
+		fmt.Fprintf(os.Stderr, "-scrape-timeout must be positive, got %s\n", scrapeOpts.Timeout)
+		os.Exit(2)
+	}
+	if *noValidate && *validateOnly != "" {
+		fmt.Fprintln(os.Stderr, "-no-validate and -validate-only are mutually exclusive")
+		os.Exit(2)
+	}
+	if *noValidate && (*serveAddr != "" || *rotateAddr != "") {
+		fmt.Fprintln(os.Stderr, "-no-validate can't be combined with -serve or -rotate, which only hand out validated proxies")
+		os.Exit(2)
+	}
+	if !slices.Contains(sortModes, *sortMode) {
+		fmt.Fprintf(os.Stderr, "unknown -sort %q (want one of: %s)\n", *sortMode, strings.Join(sortModes, ", "))
+		os.Exit(2)
+	}
+	if *shuffleOutput {
+		if sortGiven && *sortMode != "none" {
+			fmt.Fprintf(os.Stderr, "-shuffle-output and -sort %s are mutually exclusive\n", *sortMode)
+			os.Exit(2)
+		}
+		*sortMode = "none"
+	}
+	if *maxLatency < 0 {
+		fmt.Fprintf(os.Stderr, "-max-latency must not be negative, got %s\n", *maxLatency)
+		os.Exit(2)
+	}
+	if *limit < 0 {
+		fmt.Fprintf(os.Stderr, "-limit must not be negative, got %d\n", *limit)
+		os.Exit(2)
+	}
+	if *minAlive < 0 {
+		fmt.Fprintf(os.Stderr, "-min-alive must not be negative, got %d\n", *minAlive)
+		os.Exit(2)
+	}
+	if *minAliveRatio < 0 || *minAliveRatio > 1 {
+		fmt.Fprintf(os.Stderr, "-min-alive-ratio must be between 0 and 1, got %g\n", *minAliveRatio)
+		os.Exit(2)
+	}
+	if *minAliveRatio > 0 && *noValidate {
+		fmt.Fprintln(os.Stderr, "-min-alive-ratio can't be combined with -no-validate, which checks nothing")
+		os.Exit(2)
+	}
+	if *deadTTL < 0 {
+		fmt.Fprintf(os.Stderr, "-dead-ttl must not be negative, got %s\n", *deadTTL)
+		os.Exit(2)
+	}
+	seed := *randomSeed
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	// Scraping, output order and the pool servers each get their own
+	// stream, split from the seed in a fixed order, so one's draws neither
+	// shift nor repeat another's
+	seedRand := proxyscrape.NewRand(seed)
+	scrapeOpts.Rand = seedRand.Split()
+	var shuffle *proxyscrape.Rand
+	if shuffleRand := seedRand.Split(); *shuffleOutput {
+		shuffle = shuffleRand
+	}
+	poolRand := seedRand.Split()
+	slog.Debug("random seed", "seed", seed)
+	if *upstreamProxy != "" {
+		u, err := url.Parse(*upstreamProxy)
+		if err != nil || u.Host == "" || !slices.Contains([]string{"http", "https", "socks4", "socks5"}, u.Scheme) {
+			fmt.Fprintf(os.Stderr, "invalid -upstream-proxy %q (want http://, https://, socks4:// or socks5://host:port)\n", *upstreamProxy)
+			os.Exit(2)
+		}
+		scrapeOpts.Proxy = u
+	}
+	if *challengeProxy != "" {
+		u, err := url.Parse(*challengeProxy)
+		if err != nil || u.Host == "" || !slices.Contains([]string{"http", "https", "socks4", "socks5"}, u.Scheme) {
+			fmt.Fprintf(os.Stderr, "invalid -challenge-proxy %q (want http://, https://, socks4:// or socks5://host:port)\n", *challengeProxy)
+			os.Exit(2)
+		}
+		scrapeOpts.ChallengeProxy = u
+	}
+	if *maxRuntime < 0 {
+		fmt.Fprintf(os.Stderr, "-max-runtime must not be negative, got %s\n", *maxRuntime)
+		os.Exit(2)
+	}
+	if *startJitter < 0 {
+		fmt.Fprintf(os.Stderr, "-start-jitter must not be negative, got %s\n", *startJitter)
+		os.Exit(2)
+	}
+	if *scrapeConcurrency < 1 {
+		fmt.Fprintf(os.Stderr, "-scrape-concurrency must be at least 1, got %d\n", *scrapeConcurrency)
+		os.Exit(2)
+	}
+	if scrapeOpts.Retries < 1 {
+		fmt.Fprintf(os.Stderr, "-scrape-retries must be at least 1, got %d\n", scrapeOpts.Retries)
+		os.Exit(2)
+	}
+	if scrapeOpts.RetryBackoff <= 0 {
+		fmt.Fprintf(os.Stderr, "-retry-backoff must be positive, got %s\n", scrapeOpts.RetryBackoff)
+		os.Exit(2)
+	}
+	if scrapeOpts.MaxPages < 1 {
+		fmt.Fprintf(os.Stderr, "-max-pages must be at least 1, got %d\n", scrapeOpts.MaxPages)
+		os.Exit(2)
+	}
+	if scrapeOpts.MaxPerSource < 0 {
+		fmt.Fprintf(os.Stderr, "-max-per-source must not be negative, got %d\n", scrapeOpts.MaxPerSource)
+		os.Exit(2)
+	}
+	if scrapeOpts.FromCache && scrapeOpts.CacheDir == "" {
+		fmt.Fprintln(os.Stderr, "-from-cache requires -cache-dir")
+		os.Exit(2)
+	}
+	if *hostDelay < 0 {
+		fmt.Fprintf(os.Stderr, "-host-delay must not be negative, got %s\n", *hostDelay)
+		os.Exit(2)
+	}
+	if *hostDelay > 0 {
+		scrapeOpts.HostLimiter = proxyscrape.NewHostLimiter(*hostDelay)
+	}
+	if *respectRobots {
+		scrapeOpts.Robots = proxyscrape.NewRobots()
+	}
+	if scrapeOpts.ProxyscrapeAPI.Timeout < 0 {
+		fmt.Fprintf(os.Stderr, "-proxyscrape-timeout must not be negative, got %s\n", scrapeOpts.ProxyscrapeAPI.Timeout)
+		os.Exit(2)
+	}
+	if c := scrapeOpts.ProxyscrapeAPI.Country; c != "" {
+		if c = strings.ToUpper(c); c == "ALL" {
+			c = "all"
+		} else if len(c) != 2 {
+			fmt.Fprintf(os.Stderr, "invalid -proxyscrape-country %q (want an ISO country code or all)\n", c)
+			os.Exit(2)
+		}
+		scrapeOpts.ProxyscrapeAPI.Country = c
+	}
+	if *interval < 0 {
+		fmt.Fprintf(os.Stderr, "-interval must not be negative, got %s\n", *interval)
+		os.Exit(2)
+	}
+	if opts.Timeout <= 0 {
+		fmt.Fprintf(os.Stderr, "-validate-timeout must be positive, got %s\n", opts.Timeout)
+		os.Exit(2)
+	}
+	if u, err := url.Parse(opts.CheckURL); err != nil || u.Host == "" {
+		fmt.Fprintf(os.Stderr, "invalid -check-url %q\n", opts.CheckURL)
+		os.Exit(2)
+	}
+	if opts.TargetURL != "" {
+		if u, err := url.Parse(opts.TargetURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Fprintf(os.Stderr, "invalid -target %q (want an http or https URL)\n", opts.TargetURL)
+			os.Exit(2)
+		}
+	}
+	if u, err := url.Parse(opts.HTTPSCheckURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		fmt.Fprintf(os.Stderr, "invalid -https-check-url %q (must be an https URL)\n", opts.HTTPSCheckURL)
+		os.Exit(2)
+	}
+
+	resolver, err := proxyscrape.NewResolver(*resolverFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -resolver: %v\n", err)
+		os.Exit(2)
+	}
+	opts.Resolver = resolver
+
+	if *minAnonymityFlag != "" {
+		var err error
+		if opts.MinAnonymity, err = proxyscrape.ParseAnonymity(*minAnonymityFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if opts.ProbeMethod == http.MethodHead && (opts.Expect != "" || opts.MinAnonymity != proxyscrape.AnonymityUnknown) {
+		slog.Warn("-probe-method HEAD returns no body; using GET for -expect and -min-anonymity")
+	}
+
+	countries, err := proxyscrape.ParseCountries(*countryFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	opts.Countries = countries
+	protocols := parseProtocols(*protocolFlag)
+	excludeNets, err := parseCIDRs(*excludeCIDR)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -exclude-cidr: %v\n", err)
+		os.Exit(2)
+	}
+	includeNets, err := parseCIDRs(*includeCIDR)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -include-cidr: %v\n", err)
+		os.Exit(2)
+	}
+	var ipFamily int
+	switch {
+	case *ipv4Only && *ipv6Only:
+		fmt.Fprintln(os.Stderr, "-ipv4-only and -ipv6-only are mutually exclusive")
+		os.Exit(2)
+	case *ipv4Only:
+		ipFamily = 4
+	case *ipv6Only:
+		ipFamily = 6
+	}
+	if ipFamily != 0 && len(includeNets) > 0 && !slices.ContainsFunc(includeNets, func(n *net.IPNet) bool {
+		return (n.IP.To4() != nil) == (ipFamily == 4)
+	}) {
+		fmt.Fprintf(os.Stderr, "-include-cidr has no IPv%d ranges, so -ipv%d-only would keep nothing\n", ipFamily, ipFamily)
+		os.Exit(2)
+	}
+
+	switch {
+	case *userAgent != "":
+		scrapeOpts.UserAgents = []string{*userAgent}
+	case *userAgentsFile != "":
+		agents, _, err := readLines(*userAgentsFile)
+		if err != nil {
+			slog.Error("reading user agents", "error", err)
+			os.Exit(1)
+		}
+		if len(agents) == 0 {
+			fmt.Fprintf(os.Stderr, "%s contains no user agents\n", *userAgentsFile)
+			os.Exit(2)
+		}
+		scrapeOpts.UserAgents = agents
+	}
+
+	var store *proxyscrape.Store
+	if *dbPath != "" {
+		var err error
+		if store, err = proxyscrape.OpenStore(*dbPath); err != nil {
+			slog.Error("opening database", "file", *dbPath, "error", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+	}
+	if *dbTop > 0 {
+		if store == nil {
+			fmt.Fprintln(os.Stderr, "-db-top requires -db")
+			os.Exit(2)
+		}
+		if err := printTop(store, *dbTop); err != nil {
+			slog.Error("querying database", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sources := proxyscrape.DefaultSources
+	var custom []string
+	haveCustom := true
+	if *sourcesFile != "" {
+		var err error
+		if custom, err = loadSources(*sourcesFile); err != nil {
+			slog.Error("reading sources", "error", err)
+			os.Exit(1)
+		}
+	} else if cfg != nil && len(cfg.Sources) > 0 {
+		custom = cfg.Sources
+	} else {
+		haveCustom = false
+	}
+	if haveCustom {
+		if *appendSources {
+			sources = append(slices.Clip(sources), custom...)
+		} else {
+			sources = custom
+		}
+	} else if *appendSources {
+		fmt.Fprintln(os.Stderr, "-append-sources requires -sources or sources in -config")
+		os.Exit(2)
+	}
+	sources = dedupSources(sources)
+
+	var dead *proxyscrape.DeadSet
+	if *deadTTL > 0 {
+		if *deadFile == "" {
+			*deadFile = *output + ".dead"
+			if *splitBy != "" {
+				*deadFile = filepath.Join(*output, "dead")
 			}
-		}()
+		}
+		var err error
+		if dead, err = proxyscrape.LoadDeadSet(*deadFile, *deadTTL); err != nil {
+			slog.Error("reading dead proxies", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("loaded recently dead proxies", "count", dead.Len(), "file", *deadFile)
+	}
+
+	var seeds []proxyscrape.Proxy
+	if *seedFile != "" {
+		var err error
+		if seeds, err = loadProxies(*seedFile, "seed"); err != nil {
+			slog.Error("reading seed proxies", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("loaded seed proxies", "count", len(seeds), "file", *seedFile)
 	}
 
-	// Close channels when done
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	go func() {
-		wg.Wait()
-		close(proxyChan)
+		// Restore default signal handling so a second Ctrl-C exits at once.
+		<-sigCtx.Done()
+		stop()
 	}()
+	ctx := sigCtx
+	if *maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(sigCtx, *maxRuntime)
+		defer cancel()
+	}
+
+	if (opts.MinAnonymity != proxyscrape.AnonymityUnknown || opts.DropTransparent) && !*noValidate {
+		realIP, err := proxyscrape.LookupPublicIPUsing(ctx, opts.Resolver)
+		if err != nil {
+			slog.Error("looking up public IP for anonymity detection", "error", err)
+			os.Exit(1)
+		}
+		opts.RealIP = realIP
+	}
 
+	if *validateOnly != "" {
+		if err := validateInput(ctx, *validateOnly, *format, *annotate, *sortMode, shuffle, opts, protocols); err != nil {
+			slog.Error("validating input", "error", err)
+			os.Exit(1)
+		}
+		reportDeadline(ctx, *maxRuntime)
+		return
+	}
+
+	pool := &proxyscrape.ProxyPool{Rand: poolRand}
+	var servers []*http.Server
+	if *serveAddr != "" {
+		servers = append(servers, startServer("pool server", *serveAddr, proxyscrape.NewServer(pool)))
+	}
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler())
+		servers = append(servers, startServer("metrics server", *metricsAddr, mux))
+	}
+	if *rotateAddr != "" {
+		servers = append(servers, startServer("rotating proxy", *rotateAddr, proxyscrape.NewRotatingProxy(pool, opts.Timeout)))
+	}
+	// Sources registered by name fetch in their own way, so have no
+	// cached pages
+	var registered []string
+	for _, name := range proxyscrape.RegisteredSources() {
+		if !slices.Contains(proxyscrape.DefaultSources, name) {
+			registered = append(registered, name)
+		}
+	}
+	if scrapeOpts.FromCache {
+		registered = nil
+		*startJitter = 0
+	}
+	if len(disabled) > 0 {
+		sources, registered = disableSources(disabled, sources, registered)
+	}
+	for _, source := range sources {
+		if !slices.Contains(proxyscrape.RegisteredSources(), source) {
+			proxyscrape.RegisterSource(source, proxyscrape.URLSource(source))
+		}
+	}
+	r := &runner{
+		sources:           slices.Concat(sources, registered),
+		seeds:             seeds,
+		scrapeOpts:        scrapeOpts,
+		scrapeConcurrency: *scrapeConcurrency,
+		validateOpts:      opts,
+		noValidate:        *noValidate,
+		progress:          *showProgress,
+		quiet:             *quiet,
+		limit:             *limit,
+		minAlive:          *minAlive,
+		minAliveRatio:     *minAliveRatio,
+		maxSourceFailures: *maxSourceFailures,
+		maxLatency:        *maxLatency,
+		sortMode:          *sortMode,
+		shuffle:           shuffle,
+		startJitter:       *startJitter,
+		protocols:         protocols,
+		excludeNets:       excludeNets,
+		includeNets:       includeNets,
+		ipFamily:          ipFamily,
+		format:            *format,
+		annotate:          *annotate,
+		output:            *output,
+		stream:            stream,
+		splitBy:           *splitBy,
+		store:             store,
+		dead:              dead,
+		deadFile:          *deadFile,
+		dbPath:            *dbPath,
+		pool:              pool,
+		jsonSummary:       *jsonSummary,
+	}
+
+	interrupted := r.cycle(ctx)
+	for *interval > 0 && !interrupted {
+		slog.Info("next refresh scheduled", "in", *interval)
+		select {
+		case <-time.After(*interval):
+			interrupted = r.cycle(ctx)
+		case <-ctx.Done():
+			interrupted = true
+		}
+	}
+
+	if len(servers) > 0 {
+		if !interrupted {
+			slog.Info("validation finished, still serving until interrupted")
+			<-ctx.Done()
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, server := range servers {
+			server.Shutdown(shutdownCtx)
+		}
+	}
+	reportDeadline(ctx, *maxRuntime)
+	if r.failure != nil {
+		// Whatever could be is saved; the exit code tells automation the
+		// sources may have stopped working.
+		fmt.Fprintln(os.Stderr, r.failure)
+		if store != nil {
+			store.Close()
+		}
+		os.Exit(1)
+	}
+}
+
+// reportDeadline logs whether ctx ended because -max-runtime elapsed.
+func reportDeadline(ctx context.Context, maxRuntime time.Duration) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.Warn("run cut short by -max-runtime", "max_runtime", maxRuntime)
+	} else if maxRuntime > 0 {
+		slog.Info("run finished within -max-runtime", "max_runtime", maxRuntime)
+	}
+}
+
+// startServer listens on addr and serves handler in the background, exiting
+// the program if the address can't be bound.
+func startServer(name, addr string, handler http.Handler) *http.Server {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("starting "+name, "addr", addr, "error", err)
+		os.Exit(1)
+	}
+	server := &http.Server{Handler: handler}
 	go func() {
-		validatorWg.Wait()
-		close(validChan)
+		if err := server.Serve(ln); err != http.ErrServerClosed {
+			slog.Error(name+" failed", "error", err)
+		}
 	}()
+	slog.Info(name+" listening", "addr", ln.Addr().String())
+	return server
+}
 
-	// Collect valid proxies
-	var validProxies []string
-	for proxy := range validChan {
-		pool.Add(proxy)
-		validProxies = append(validProxies, proxy)
-		fmt.Printf("Valid proxy found: %s\n", proxy)
+// proxyProtocol returns the normalized scheme of proxy.
+func proxyProtocol(proxy proxyscrape.Proxy) string {
+	scheme, _, _ := strings.Cut(proxyscrape.NormalizeProxy(proxy.String()), "://")
+	return scheme
+}
+
+// sortModes lists the values -sort accepts.
+var sortModes = []string{"latency", "none"}
+
+// sortProxies orders proxies in place for saving. "latency" puts the
+// fastest first, breaking ties by address so output is deterministic;
+// "none" keeps the order they finished validating in.
+func sortProxies(proxies []proxyscrape.Proxy, mode string) {
+	if mode != "latency" {
+		return
 	}
+	slices.SortStableFunc(proxies, func(a, b proxyscrape.Proxy) int {
+		if c := cmp.Compare(a.Latency, b.Latency); c != 0 {
+			return c
+		}
+		return cmp.Compare(net.JoinHostPort(a.IP, a.Port), net.JoinHostPort(b.IP, b.Port))
+	})
+}
 
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
+// shuffleProxies puts proxies in an order drawn from rnd. They're sorted by
+// address first so the order depends on nothing but rnd's seed.
+func shuffleProxies(proxies []proxyscrape.Proxy, rnd *proxyscrape.Rand) {
+	slices.SortFunc(proxies, func(a, b proxyscrape.Proxy) int {
+		return cmp.Compare(a.String(), b.String())
+	})
+	rnd.Shuffle(len(proxies), func(i, j int) { proxies[i], proxies[j] = proxies[j], proxies[i] })
+}
+
+// parseProtocols turns a comma-separated list of schemes into a set.
+func parseProtocols(list string) map[string]bool {
+	protocols := make(map[string]bool)
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			protocols[p] = true
+		}
+	}
+	return protocols
+}
+
+// parseCIDRs parses a comma-separated list of CIDR ranges.
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// protocolCounts returns how many of proxies use each protocol.
+func protocolCounts(proxies []proxyscrape.Proxy) map[string]int {
+	counts := make(map[string]int)
+	for _, p := range proxies {
+		counts[proxyProtocol(p)]++
+	}
+	return counts
+}
+
+// logProtocolCounts summarizes how many proxies of each protocol were kept.
+func logProtocolCounts(proxies []proxyscrape.Proxy) {
+	counts := protocolCounts(proxies)
+	var attrs []any
+	for _, protocol := range slices.Sorted(maps.Keys(counts)) {
+		attrs = append(attrs, protocol, counts[protocol])
 	}
-	fileName := filepath.Join(homeDir, ".proxychains", "proxies")
-	saveProxies(fileName, validProxies)
-	fmt.Printf("\nTotal valid proxies: %d\n", len(validProxies))
+	slog.Info("valid proxies by protocol", attrs...)
 }
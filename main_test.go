@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdir changes into dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestDefaultOutputPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	got, err := defaultOutputPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, ".proxychains", "proxies"); got != want {
+		t.Errorf("defaultOutputPath() = %q, want %q", got, want)
+	}
+}
+
+func TestDefaultOutputPathNoHome(t *testing.T) {
+	t.Setenv("HOME", "")
+	wd := t.TempDir()
+	chdir(t, wd)
+
+	got, err := defaultOutputPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(wd, "proxies"); got != want {
+		t.Errorf("defaultOutputPath() with HOME unset = %q, want %q", got, want)
+	}
+}
+
+func TestDefaultOutputPathUnwritableDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to any directory")
+	}
+	t.Setenv("HOME", "")
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	wd := t.TempDir()
+	if err := os.Chmod(wd, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(wd, 0o755) })
+	chdir(t, wd)
+
+	got, err := defaultOutputPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(tmp, "proxies"); got != want {
+		t.Errorf("defaultOutputPath() in a read-only directory = %q, want %q", got, want)
+	}
+}
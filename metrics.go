@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are always updated as the run progresses; -metrics-addr only
+// controls whether they're served.
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	proxiesScraped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxies_scraped_total",
+		Help: "Proxies scraped, before deduplication, by source.",
+	}, []string{"source"})
+
+	proxiesValidated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxies_validated_total",
+		Help: "Proxies checked, by result (alive or dead).",
+	}, []string{"result"})
+
+	proxiesAlive = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxies_alive_total",
+		Help: "Proxies that passed validation, by source.",
+	}, []string{"source"})
+
+	validationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "validation_failures_total",
+		Help: "Dead proxies, by failure reason.",
+	}, []string{"reason"})
+
+	liveProxies = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "current_live_proxies",
+		Help: "Proxies currently in the live pool.",
+	})
+
+	validationLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "validation_latency_seconds",
+		Help:    "Round-trip time of successful proxy checks.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+
+	scrapeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "scrape_errors_total",
+		Help: "Failed source fetches, by source.",
+	}, []string{"source"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(proxiesScraped, proxiesValidated, proxiesAlive, validationFailures, liveProxies, validationLatency, scrapeErrors)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progress counts one cycle's work for the -progress status line. The
+// counters are updated from the pipeline goroutines.
+type progress struct {
+	start   time.Time
+	scraped atomic.Int64 // unique candidates queued for validation
+	checked atomic.Int64
+	live    atomic.Int64
+}
+
+// isTerminal reports whether f is a character device, i.e. an interactive
+// terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// report writes the status line to stdout until done is closed. On a
+// terminal the line is redrawn in place; otherwise a new line is written
+// every few seconds so logs and pipes stay readable.
+func (p *progress) report(done <-chan struct{}) {
+	tty := isTerminal(os.Stdout)
+	every := 5 * time.Second
+	if tty {
+		every = 500 * time.Millisecond
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.print(os.Stdout, tty)
+		case <-done:
+			if tty {
+				p.print(os.Stdout, tty)
+				fmt.Fprintln(os.Stdout)
+			}
+			return
+		}
+	}
+}
+
+func (p *progress) print(w io.Writer, tty bool) {
+	checked := p.checked.Load()
+	var rate float64
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = float64(checked) / elapsed
+	}
+	line := fmt.Sprintf("scraped %d, validated %d, live %d, %.1f/s",
+		p.scraped.Load(), checked, p.live.Load(), rate)
+	if tty {
+		fmt.Fprintf(w, "\r\033[K%s", line)
+	} else {
+		fmt.Fprintln(w, line)
+	}
+}
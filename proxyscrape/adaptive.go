@@ -0,0 +1,73 @@
+package proxyscrape
+
+import "sync"
+
+// adaptWindow is the fewest checks a concurrency adjustment is based on.
+const adaptWindow = 20
+
+// Timeout rates of a window of checks above which workerGate halves its
+// limit and below which it raises it by a quarter.
+const (
+	adaptBackoffRate = 0.5
+	adaptGrowRate    = 0.2
+)
+
+// workerGate caps how many workers check proxies at once, adjusting the
+// cap between min and max from the timeout rate of recent checks: a
+// saturated link shows up as a burst of timeouts, so concurrency backs off
+// until checks complete again.
+type workerGate struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	active   int
+	limit    int
+	min, max int
+	checks   int // in the current window
+	timeouts int
+	onChange func(workers int)
+}
+
+func newWorkerGate(min, max int, onChange func(int)) *workerGate {
+	g := &workerGate{limit: min, min: min, max: max, onChange: onChange}
+	g.cond = sync.NewCond(&g.mu)
+	if onChange != nil {
+		onChange(min)
+	}
+	return g
+}
+
+// acquire blocks until the worker may run a check.
+func (g *workerGate) acquire() {
+	g.mu.Lock()
+	for g.active >= g.limit {
+		g.cond.Wait()
+	}
+	g.active++
+	g.mu.Unlock()
+}
+
+// release ends a check, recording whether it timed out, and adjusts the
+// limit once a full window of checks is in.
+func (g *workerGate) release(timedOut bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	g.checks++
+	if timedOut {
+		g.timeouts++
+	}
+	if g.checks >= max(adaptWindow, g.limit) {
+		old := g.limit
+		switch rate := float64(g.timeouts) / float64(g.checks); {
+		case rate > adaptBackoffRate:
+			g.limit = max(g.min, g.limit/2)
+		case rate < adaptGrowRate:
+			g.limit = min(g.max, g.limit+max(1, g.limit/4))
+		}
+		g.checks, g.timeouts = 0, 0
+		if g.limit != old && g.onChange != nil {
+			g.onChange(g.limit)
+		}
+	}
+	g.cond.Broadcast()
+}
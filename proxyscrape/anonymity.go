@@ -0,0 +1,182 @@
+package proxyscrape
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Anonymity is how much a proxy reveals about the client behind it.
+type Anonymity int
+
+const (
+	AnonymityUnknown Anonymity = iota
+	Transparent                // forwards our real IP
+	Anonymous                  // hides our IP but announces itself as a proxy
+	Elite                      // indistinguishable from a direct client
+)
+
+func (a Anonymity) String() string {
+	switch a {
+	case Transparent:
+		return "transparent"
+	case Anonymous:
+		return "anonymous"
+	case Elite:
+		return "elite"
+	}
+	return ""
+}
+
+// ParseAnonymity parses a level name as printed by Anonymity.String.
+func ParseAnonymity(s string) (Anonymity, error) {
+	for _, a := range []Anonymity{Transparent, Anonymous, Elite} {
+		if strings.EqualFold(s, a.String()) {
+			return a, nil
+		}
+	}
+	return AnonymityUnknown, fmt.Errorf("unknown anonymity level %q (want transparent, anonymous or elite)", s)
+}
+
+// publicIPURL returns the caller's IP as plain text.
+const publicIPURL = "https://api.ipify.org"
+
+// LookupPublicIP fetches our own IP directly, without any proxy, so judge
+// responses can be checked for leaks.
+func LookupPublicIP(ctx context.Context) (string, error) {
+	return LookupPublicIPUsing(ctx, nil)
+}
+
+// LookupPublicIPUsing is LookupPublicIP resolving the lookup's host with
+// resolver, or the system resolver if it is nil.
+func LookupPublicIPUsing(ctx context.Context, resolver *net.Resolver) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", publicIPURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := directClient(resolver).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", err
+	}
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("unexpected response from %s: %q", publicIPURL, ip)
+	}
+	return ip, nil
+}
+
+// proxyHeaders are request headers that proxies add to identify themselves.
+var proxyHeaders = []string{
+	"via",
+	"forwarded",
+	"x-forwarded-for",
+	"x-forwarded-host",
+	"x-real-ip",
+	"x-proxy-id",
+	"proxy-connection",
+	"client-ip",
+}
+
+// DetectAnonymity fetches the judge through the proxy and classifies it by
+// what the judge saw: our real IP, either as the address the request came
+// from or in its headers, proxy headers, or neither.
+func DetectAnonymity(ctx context.Context, proxy string, opts ValidateOptions) (Anonymity, error) {
+	opts = opts.withDefaults()
+	u, err := proxyURL(proxy)
+	if err != nil {
+		return AnonymityUnknown, err
+	}
+	client, release, err := opts.proxyClient(u)
+	if err != nil {
+		return AnonymityUnknown, err
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", opts.Judge, nil)
+	if err != nil {
+		return AnonymityUnknown, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return AnonymityUnknown, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return AnonymityUnknown, fmt.Errorf("judge returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBody))
+	if err != nil {
+		return AnonymityUnknown, err
+	}
+	return classifyAnonymity(body, opts.RealIP), nil
+}
+
+func classifyAnonymity(body []byte, realIP string) Anonymity {
+	// A proxy that passes requests straight through adds no headers, but
+	// the judge sees them come from us.
+	var echo struct {
+		Origin string `json:"origin"`
+	}
+	if realIP != "" && json.Unmarshal(body, &echo) == nil {
+		for _, ip := range strings.Split(echo.Origin, ",") {
+			if strings.TrimSpace(ip) == realIP {
+				return Transparent
+			}
+		}
+	}
+	headers := judgeHeaders(body)
+	for _, v := range headers {
+		if realIP != "" && strings.Contains(v, realIP) {
+			return Transparent
+		}
+	}
+	for _, name := range proxyHeaders {
+		if _, ok := headers[name]; ok {
+			return Anonymous
+		}
+	}
+	return Elite
+}
+
+// judgeHeaders extracts the echoed request headers, keyed by lowercased name.
+// httpbin-style JSON is understood directly; anything else (azenv.php and
+// friends) is scanned for NAME = value or NAME: value lines, with CGI-style
+// HTTP_X_FORWARDED_FOR names mapped back to their header form.
+func judgeHeaders(body []byte) map[string]string {
+	headers := make(map[string]string)
+
+	var echo struct {
+		Headers map[string]string `json:"headers"`
+	}
+	if json.Unmarshal(body, &echo) == nil && echo.Headers != nil {
+		for k, v := range echo.Headers {
+			headers[strings.ToLower(k)] = v
+		}
+		return headers
+	}
+
+	for _, line := range strings.Split(string(body), "\n") {
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			name, value, ok = strings.Cut(line, ":")
+		}
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		name = strings.ReplaceAll(strings.TrimPrefix(name, "http_"), "_", "-")
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers
+}
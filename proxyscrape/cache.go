@@ -0,0 +1,49 @@
+package proxyscrape
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pageCachePath is the file the body of pageURL is cached in under dir:
+// the host, for finding a source's pages by eye, and a hash of the full
+// URL so every page of a source gets its own file.
+func pageCachePath(dir, pageURL string) string {
+	host := "source"
+	if u, err := url.Parse(pageURL); err == nil && u.Host != "" {
+		host = strings.NewReplacer(":", "_", "/", "_").Replace(u.Host)
+	}
+	sum := sha256.Sum256([]byte(pageURL))
+	return filepath.Join(dir, host+"-"+hex.EncodeToString(sum[:6])+".body")
+}
+
+// readCachedPage returns the cached body of pageURL.
+func readCachedPage(dir, pageURL string) (io.ReadCloser, error) {
+	data, err := os.ReadFile(pageCachePath(dir, pageURL))
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// cachePage reads body to the end, saves it as the cached body of pageURL
+// and returns a reader over it for parsing.
+func cachePage(dir, pageURL string, body io.Reader) (io.ReadCloser, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(pageCachePath(dir, pageURL), data, 0o644); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
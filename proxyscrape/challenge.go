@@ -0,0 +1,51 @@
+package proxyscrape
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ErrChallenge is returned, wrapped, when a source answers with an
+// anti-bot challenge, such as Cloudflare's "Just a moment..." page, instead
+// of its proxy list. Retrying from the same address doesn't get past one.
+var ErrChallenge = errors.New("blocked by challenge")
+
+// challengePeek is how much of a body is searched for challengeMarkers;
+// they all sit in the head of the page.
+const challengePeek = 16 << 10
+
+// challengeMarkers are lowercase strings found in challenge pages and not
+// in the pages or APIs proxy lists are served from.
+var challengeMarkers = [][]byte{
+	[]byte("cf-browser-verification"),
+	[]byte("cf_chl_opt"),
+	[]byte("/cdn-cgi/challenge-platform/"),
+	[]byte("<title>just a moment...</title>"),
+	[]byte("<title>attention required! | cloudflare</title>"),
+	[]byte("ddos-guard"),
+}
+
+// isChallenge reports whether resp is a challenge page, going by
+// Cloudflare's cf-mitigated header or markers near the start of the body.
+// resp.Body is replaced by one that still yields what was searched.
+func isChallenge(resp *http.Response) bool {
+	if resp.Header.Get("Cf-Mitigated") == "challenge" {
+		return true
+	}
+	br := bufio.NewReaderSize(resp.Body, challengePeek)
+	head, _ := br.Peek(challengePeek)
+	head = bytes.ToLower(head)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, resp.Body}
+	for _, marker := range challengeMarkers {
+		if bytes.Contains(head, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package proxyscrape
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeadSet remembers proxies that recently failed validation so repeated
+// runs can skip them until ttl has passed. It is keyed by protocol, IP and
+// port, so a dead socks5 proxy doesn't hide an http one on the same
+// address, and kept on disk as one "protocol://ip:port unix-time" line per
+// proxy. It is safe for concurrent use.
+type DeadSet struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	dead map[string]time.Time // protocol://ip:port -> when it last failed
+}
+
+// LoadDeadSet reads the dead set at path, dropping entries older than ttl.
+// A missing file yields an empty set.
+func LoadDeadSet(path string, ttl time.Duration) (*DeadSet, error) {
+	d := &DeadSet{ttl: ttl, dead: make(map[string]time.Time)}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		key, stamp, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		secs, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad timestamp %q", path, lineNo, stamp)
+		}
+		if failed := time.Unix(secs, 0); time.Since(failed) < ttl {
+			d.dead[key] = failed
+		}
+	}
+	return d, scanner.Err()
+}
+
+// deadKey leaves out any credentials, which aren't written to disk.
+func deadKey(p Proxy) string {
+	return p.Protocol + "://" + net.JoinHostPort(p.IP, p.Port)
+}
+
+// Contains reports whether p failed within the last ttl.
+func (d *DeadSet) Contains(p Proxy) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	failed, ok := d.dead[deadKey(p)]
+	return ok && time.Since(failed) < d.ttl
+}
+
+// Record notes the outcome of checking p: a dead proxy is added with the
+// current time and a live one removed.
+func (d *DeadSet) Record(p Proxy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p.HTTPOK {
+		delete(d.dead, deadKey(p))
+	} else {
+		d.dead[deadKey(p)] = time.Now()
+	}
+}
+
+// Len returns the number of proxies in the set.
+func (d *DeadSet) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.dead)
+}
+
+// Encode writes the unexpired entries to w in the format LoadDeadSet
+// reads. Callers saving them should write a new file and rename it into
+// place, so a crash or a concurrent run never leaves a truncated one.
+func (d *DeadSet) Encode(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, failed := range d.dead {
+		if time.Since(failed) < d.ttl {
+			if _, err := fmt.Fprintf(w, "%s %d\n", key, failed.Unix()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package proxyscrape
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeadSetKeysOnProtocol(t *testing.T) {
+	d, err := LoadDeadSet(filepath.Join(t.TempDir(), "dead"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socks := Proxy{IP: "1.2.3.4", Port: "1080", Protocol: "socks5"}
+	http := Proxy{IP: "1.2.3.4", Port: "1080", Protocol: "http"}
+	d.Record(socks)
+	if !d.Contains(socks) {
+		t.Error("dead socks5 proxy not in the set")
+	}
+	if d.Contains(http) {
+		t.Error("a dead socks5 proxy hid the http proxy on the same address")
+	}
+	http.HTTPOK = true
+	d.Record(http)
+	if !d.Contains(socks) {
+		t.Error("a live http proxy took the socks5 proxy on the same address out of the set")
+	}
+}
+
+func TestDeadSetEncode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead")
+	d, err := LoadDeadSet(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := Proxy{IP: "1.2.3.4", Port: "8080", Protocol: "http", Username: "user", Password: "secret"}
+	d.Record(dead)
+	var buf bytes.Buffer
+	if err := d.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Errorf("Encode wrote credentials: %q", buf.String())
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadDeadSet(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 1 || !loaded.Contains(dead) {
+		t.Errorf("reloaded set has %d entries and Contains(%s) = %v, want the one dead proxy", loaded.Len(), dead, loaded.Contains(dead))
+	}
+}
@@ -0,0 +1,249 @@
+package proxyscrape
+
+import (
+	"encoding/base64"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	atobCall     = regexp.MustCompile(`atob\(\s*["']([^"']+)["']\s*\)`)
+	fromCharCode = regexp.MustCompile(`String\.fromCharCode\(([^)]*)\)`)
+	arrayJoin    = regexp.MustCompile(`\[((?:\s*(?:"[^"]*"|'[^']*')\s*,?)*)\]\.join\(\s*("[^"]*"|'[^']*')?\s*\)`)
+	stringLit    = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+	stringAssign = regexp.MustCompile(`(?:\b(?:var|let|const)\s+)?\b([A-Za-z_$][\w$]*)\s*=\s*("[^"]*"|'[^']*')\s*[;,\n]`)
+	writeCall    = regexp.MustCompile(`document\.write\((.*)\)`)
+	hostPort     = regexp.MustCompile(`(?:^|[^\d.])((?:\d{1,3}\.){3}\d{1,3})(?::(\d{1,5}))?(?:$|[^\d.:])`)
+	scriptDigits = regexp.MustCompile(`[\d.:]+`)
+)
+
+// deobfuscateAddr recovers the address an inline script writes into the
+// page. Sites hide it behind base64 (atob), String.fromCharCode, array
+// joins, reversed strings, ROT13 and string variables concatenated inside
+// document.write; anything else falls back to the digits, dots and colons
+// in the script. ip is empty unless a valid IP was found, and port is
+// empty unless a valid port follows it.
+func deobfuscateAddr(js string) (ip, port string) {
+	var written string
+	if match := atobCall.FindStringSubmatch(js); match != nil {
+		decoded, _ := base64.StdEncoding.DecodeString(match[1])
+		written = string(decoded)
+	} else if s, ok := evalWrite(expandLiterals(js)); ok {
+		written = s
+	} else {
+		// trimmed, or the dot in document.write runs into the address
+		written = strings.TrimLeft(strings.Join(scriptDigits.FindAllString(js, -1), ""), ".:")
+	}
+
+	m := hostPort.FindStringSubmatch(written)
+	if m == nil || !isValidIP(m[1]) {
+		return "", ""
+	}
+	if isValidPort(m[2]) {
+		port = m[2]
+	}
+	return m[1], port
+}
+
+// expandLiterals replaces String.fromCharCode calls and joins of string
+// array literals with the string literal they evaluate to.
+func expandLiterals(js string) string {
+	js = fromCharCode.ReplaceAllStringFunc(js, func(call string) string {
+		args := fromCharCode.FindStringSubmatch(call)[1]
+		var b strings.Builder
+		for _, arg := range strings.Split(args, ",") {
+			code, err := strconv.ParseInt(strings.TrimSpace(arg), 0, 32)
+			if err != nil {
+				return call
+			}
+			b.WriteRune(rune(code))
+		}
+		return strconv.Quote(b.String())
+	})
+	return arrayJoin.ReplaceAllStringFunc(js, func(call string) string {
+		m := arrayJoin.FindStringSubmatch(call)
+		sep := "," // JavaScript's default separator
+		if m[2] != "" {
+			sep = unquote(m[2])
+		}
+		var parts []string
+		for _, lit := range stringLit.FindAllStringSubmatch(m[1], -1) {
+			parts = append(parts, lit[1]+lit[2])
+		}
+		return strconv.Quote(strings.Join(parts, sep))
+	})
+}
+
+// evalWrite evaluates the argument of the script's document.write call when
+// it is a "+" concatenation of terms evalTerm understands.
+func evalWrite(js string) (string, bool) {
+	call := writeCall.FindStringSubmatch(js)
+	if call == nil {
+		return "", false
+	}
+	vars := make(map[string]string)
+	for _, m := range stringAssign.FindAllStringSubmatch(js, -1) {
+		vars[m[1]] = unquote(m[2])
+	}
+
+	return evalConcat(call[1], vars)
+}
+
+// evalConcat evaluates a "+" concatenation of terms.
+func evalConcat(expr string, vars map[string]string) (string, bool) {
+	var b strings.Builder
+	for _, term := range splitConcat(expr) {
+		value, ok := evalTerm(strings.TrimSpace(term), vars)
+		if !ok {
+			return "", false
+		}
+		b.WriteString(value)
+	}
+	return b.String(), true
+}
+
+var (
+	// reverseCall matches x.split("").reverse().join("").
+	reverseCall = regexp.MustCompile(`^(.+?)\.split\(\s*(?:""|'')\s*\)\.reverse\(\)\.join\(\s*(?:""|'')\s*\)$`)
+	// rot13Call matches a call to the usual names of a ROT13 helper.
+	rot13Call = regexp.MustCompile(`^(?:str_)?rot13\((.*)\)$`)
+)
+
+// evalTerm evaluates one term of a concatenation: a string literal, a
+// variable assigned one, a parenthesized concatenation, or any of those
+// reversed with split/reverse/join or passed through a rot13 helper.
+func evalTerm(term string, vars map[string]string) (string, bool) {
+	if m := reverseCall.FindStringSubmatch(term); m != nil {
+		value, ok := evalTerm(m[1], vars)
+		return reverse(value), ok
+	}
+	if m := rot13Call.FindStringSubmatch(term); m != nil {
+		value, ok := evalConcat(m[1], vars)
+		return rot13(value), ok
+	}
+	switch {
+	case term == "":
+		return "", false
+	case stringLit.FindString(term) == term:
+		return unquote(term), true
+	case strings.HasPrefix(term, "(") && strings.HasSuffix(term, ")"):
+		return evalConcat(term[1:len(term)-1], vars)
+	}
+	value, ok := vars[term]
+	return value, ok && value != ""
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	slices.Reverse(r)
+	return string(r)
+}
+
+// rot13 rotates ASCII letters by 13 places, leaving everything else.
+func rot13(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, s)
+}
+
+// splitConcat splits expr on the "+" operators outside string literals
+// and parentheses.
+func splitConcat(expr string) []string {
+	var terms []string
+	var quote rune
+	depth, start := 0, 0
+	for i, r := range expr {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == '+' && depth == 0:
+			terms = append(terms, expr[start:i])
+			start = i + 1
+		}
+	}
+	return append(terms, expr[start:])
+}
+
+// unquote strips the quotes from a JavaScript string literal.
+func unquote(lit string) string {
+	if s, err := strconv.Unquote(lit); err == nil {
+		return s
+	}
+	return strings.Trim(lit, `"'`)
+}
+
+// xorAssign matches an assignment of numbers and variables XORed
+// together, as spys.one defines the digits of its ports, e.g.
+// "x4r8=6045^a5b3;".
+var xorAssign = regexp.MustCompile(`\b([A-Za-z_$][\w$]*)\s*=\s*([\w$]+(?:\s*\^\s*[\w$]+)*)\s*(?:;|\n|$)`)
+
+// xorVars evaluates, in order, the XOR assignments in js whose operands are
+// numbers or variables assigned before them.
+func xorVars(js string) map[string]int {
+	vars := make(map[string]int)
+	for _, m := range xorAssign.FindAllStringSubmatch(js, -1) {
+		if v, ok := evalXOR(m[2], vars); ok {
+			vars[m[1]] = v
+		}
+	}
+	return vars
+}
+
+// evalXOR evaluates a chain of numbers and variables joined by ^.
+func evalXOR(expr string, vars map[string]int) (int, bool) {
+	result := 0
+	for _, operand := range strings.Split(expr, "^") {
+		operand = strings.TrimSpace(operand)
+		v, err := strconv.Atoi(operand)
+		if err != nil {
+			var ok bool
+			if v, ok = vars[operand]; !ok {
+				return 0, false
+			}
+		}
+		result ^= v
+	}
+	return result, true
+}
+
+// evalXORWrite evaluates the argument of the script's document.write call
+// when it is a "+" concatenation of string literals and parenthesized XOR
+// chains over vars, each chain contributing its value in decimal.
+func evalXORWrite(js string, vars map[string]int) (string, bool) {
+	call := writeCall.FindStringSubmatch(js)
+	if call == nil {
+		return "", false
+	}
+	var b strings.Builder
+	for _, term := range splitConcat(call[1]) {
+		switch term = strings.TrimSpace(term); {
+		case term != "" && stringLit.FindString(term) == term:
+			b.WriteString(unquote(term))
+		case strings.HasPrefix(term, "(") && strings.HasSuffix(term, ")"):
+			v, ok := evalXOR(term[1:len(term)-1], vars)
+			if !ok {
+				return "", false
+			}
+			b.WriteString(strconv.Itoa(v))
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
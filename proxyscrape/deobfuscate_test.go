@@ -0,0 +1,253 @@
+package proxyscrape
+
+import (
+	"strings"
+	"testing"
+)
+
+type deobfuscateTest struct {
+	name     string
+	js       string
+	ip, port string
+}
+
+func runDeobfuscateTests(t *testing.T, tests []deobfuscateTest) {
+	t.Helper()
+	for _, tt := range tests {
+		ip, port := deobfuscateAddr(tt.js)
+		if ip != tt.ip || port != tt.port {
+			t.Errorf("%s: deobfuscateAddr(%q) = %q, %q; want %q, %q", tt.name, tt.js, ip, port, tt.ip, tt.port)
+		}
+	}
+}
+
+func TestDeobfuscateAddr(t *testing.T) {
+	runDeobfuscateTests(t, []deobfuscateTest{
+		{
+			name: "atob",
+			js:   `document.write(atob("MTg1LjIxNy4xOTkuMTc2"));`,
+			ip:   "185.217.199.176",
+		},
+		{
+			name: "atob with port",
+			js:   `document.write(atob('MTg1LjIxNy4xOTkuMTc2OjMxMjg='))`,
+			ip:   "185.217.199.176", port: "3128",
+		},
+		{
+			name: "fromCharCode",
+			js:   `document.write(String.fromCharCode(52,53,46,55,55,46,49,51,54,46,50,49,53));`,
+			ip:   "45.77.136.215",
+		},
+		{
+			name: "fromCharCode with hex codes",
+			js:   `document.write(String.fromCharCode(0x34,0x35,0x2e,0x37,0x37) + ".136.215");`,
+			ip:   "45.77.136.215",
+		},
+		{
+			name: "array join",
+			js:   `document.write(["45","77","136","215"].join("."));`,
+			ip:   "45.77.136.215",
+		},
+		{
+			name: "array join with port",
+			js:   `document.write(['45.77', '136.215'].join('.') + ':' + ['80', '80'].join(''));`,
+			ip:   "45.77.136.215", port: "8080",
+		},
+		{
+			name: "variable concat",
+			js:   "var a = \"45.77\";\nvar b = '.136';\nvar c = \".215\";\ndocument.write(a + b + c);",
+			ip:   "45.77.136.215",
+		},
+		{
+			name: "variables and literals",
+			js:   `var p1="103.152", p2="112.162"; document.write(p1 + "." + p2 + ":" + "80");`,
+			ip:   "103.152.112.162", port: "80",
+		},
+		{
+			name: "digit fallback",
+			js:   `document.write('1.2' + someHelper() + '.3.4')`,
+			ip:   "1.2.3.4",
+		},
+		{name: "no address", js: `document.write("N/A")`},
+		{name: "invalid IP", js: `document.write("999.1.1.1")`},
+		{name: "unknown variable", js: `document.write(PROXY_LOCATIONS[3])`},
+	})
+}
+
+// A proxynova table row as the site marks it up, with the IP written by a
+// script inside an abbr.
+const proxynovaPage = `<table id="tbl_proxy_list"><thead><tr><th>Proxy IP</th><th>Proxy Port</th></tr></thead>
+<tbody>
+<tr data-proxy-id="1">
+  <td align="left"><abbr title="45.77.136.215"><script>document.write(String.fromCharCode(52,53,46,55,55) + '.136.215');</script></abbr></td>
+  <td align="left">
+    8080 </td>
+</tr>
+<tr data-proxy-id="2">
+  <td align="left"><abbr><script>document.write(["103","152","112","162"].join("."));</script></abbr></td>
+  <td align="left">80</td>
+</tr>
+<tr data-proxy-id="3">
+  <td align="left"><abbr><script>var x = "185.217"; var y = ".199.176"; document.write(x + y);</script></abbr></td>
+  <td align="left">3128</td>
+</tr>
+<tr><td colspan="2"><ins class="adsbygoogle"></ins></td></tr>
+</tbody></table>`
+
+func TestProxynovaParser(t *testing.T) {
+	got := parseAll(t, proxynovaParser{}, "https://www.proxynova.com/proxy-server-list/", proxynovaPage)
+	want := []string{
+		"http://45.77.136.215:8080",
+		"http://103.152.112.162:80",
+		"http://185.217.199.176:3128",
+	}
+	assertProxies(t, got, want)
+}
+
+// free-proxy-list.net lists plain text cells; its sister sites write the
+// IP cell with a script instead.
+const freeProxyListPage = `<table class="table table-striped table-bordered"><thead><tr>
+<th>IP Address</th><th>Port</th><th>Code</th><th>Country</th><th>Anonymity</th><th>Google</th><th>Https</th><th>Last Checked</th>
+</tr></thead><tbody>
+<tr><td>47.74.152.29</td><td>8888</td><td>SG</td><td>Singapore</td><td>anonymous</td><td>no</td><td>yes</td><td>10 secs ago</td></tr>
+<tr><td>160.86.242.23</td><td>8080</td><td>JP</td><td>Japan</td><td>elite proxy</td><td>no</td><td>yes</td><td>10 secs ago</td></tr>
+</tbody></table>
+<script>document.write(atob("MTM5LjU5LjEuMTQ6ODA4MA=="));</script>`
+
+func TestTableParserFreeProxyList(t *testing.T) {
+	got := parseAll(t, tableParser{}, "https://free-proxy-list.net/", freeProxyListPage)
+	assertProxies(t, got, []string{
+		"http://47.74.152.29:8888",
+		"http://160.86.242.23:8080",
+		"http://139.59.1.14:8080",
+	})
+	if got[0].Country != "SG" || got[0].Anonymity != Anonymous || got[1].Anonymity != Elite {
+		t.Errorf("got %+v and %+v, want SG/anonymous and elite", got[0], got[1])
+	}
+}
+
+// parseAll runs p over page and returns the proxies it sent.
+func parseAll(t *testing.T, p Parser, source, page string) []Proxy {
+	t.Helper()
+	out := make(chan Proxy, 100)
+	n, _, err := p.Parse(source, strings.NewReader(page), out)
+	close(out)
+	if err != nil {
+		t.Fatalf("%s.Parse: %v", p.Name(), err)
+	}
+	var got []Proxy
+	for proxy := range out {
+		if proxy.Source != source {
+			t.Errorf("%s has Source %q, want %q", proxy, proxy.Source, source)
+		}
+		got = append(got, proxy)
+	}
+	if n != len(got) {
+		t.Errorf("%s.Parse returned n = %d but sent %d proxies", p.Name(), n, len(got))
+	}
+	return got
+}
+
+// assertProxies checks got holds the proxies want names, in order.
+func assertProxies(t *testing.T, got []Proxy, want []string) {
+	t.Helper()
+	var gotStr []string
+	for _, p := range got {
+		gotStr = append(gotStr, p.String())
+	}
+	if strings.Join(gotStr, " ") != strings.Join(want, " ") {
+		t.Errorf("got proxies %q, want %q", gotStr, want)
+	}
+}
+
+func TestDeobfuscateReversed(t *testing.T) {
+	runDeobfuscateTests(t, []deobfuscateTest{
+		{
+			name: "reversed literal",
+			js:   `document.write("512.631.77.54".split("").reverse().join(""));`,
+			ip:   "45.77.136.215",
+		},
+		{
+			name: "reversed with port",
+			js:   `document.write('0808:512.631.77.54'.split('').reverse().join(''))`,
+			ip:   "45.77.136.215", port: "8080",
+		},
+		{
+			name: "reversed variable",
+			js:   `var s = "512.631.77.54"; document.write(s.split("").reverse().join(""));`,
+			ip:   "45.77.136.215",
+		},
+		{
+			name: "reversed concatenation",
+			js:   `document.write(("512.631" + ".77.54").split("").reverse().join("") + ":3128");`,
+			ip:   "45.77.136.215", port: "3128",
+		},
+	})
+}
+
+func TestDeobfuscateROT13(t *testing.T) {
+	// ROT13 leaves digits as they are, so these check the helper call is
+	// recognized and evaluated rather than left to the digit fallback,
+	// which would take in the 13 of its name.
+	runDeobfuscateTests(t, []deobfuscateTest{
+		{
+			name: "rot13 helper",
+			js:   `document.write(rot13("45.77.136.215"));`,
+			ip:   "45.77.136.215",
+		},
+		{
+			name: "str_rot13 helper with port",
+			js:   `document.write(str_rot13("45.77.136.215:8080"));`,
+			ip:   "45.77.136.215", port: "8080",
+		},
+		{
+			name: "rot13 of a concatenation and variable",
+			js:   `var a = "45.77"; document.write(rot13(a + ".136.215") + ":" + "80");`,
+			ip:   "45.77.136.215", port: "80",
+		},
+		{
+			name: "rot13 reversed",
+			js:   `document.write(rot13("512.631.77.54").split("").reverse().join(""));`,
+			ip:   "45.77.136.215",
+		},
+	})
+}
+
+func TestROT13(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                "",
+		"uryyb":           "hello",
+		"HELLO":           "URYYB",
+		"1.2.3.4:80":      "1.2.3.4:80",
+		"Why did the 1.2": "Jul qvq gur 1.2",
+	} {
+		if got := rot13(in); got != want {
+			t.Errorf("rot13(%q) = %q, want %q", in, got, want)
+		}
+		if back := rot13(rot13(in)); back != in {
+			t.Errorf("rot13 twice turned %q into %q", in, back)
+		}
+	}
+}
+
+// TestDeobfuscateGated checks decoders only run when their pattern
+// matches, leaving scripts that merely mention the names alone.
+func TestDeobfuscateGated(t *testing.T) {
+	runDeobfuscateTests(t, []deobfuscateTest{
+		{
+			name: "reverse elsewhere in the script",
+			js:   `var r = [1,2].reverse(); document.write("45.77.136.215");`,
+			ip:   "45.77.136.215",
+		},
+		{
+			name: "rot13 named but not called on the output",
+			js:   `function rot13(s) { return s; } document.write("45.77.136.215:8080");`,
+			ip:   "45.77.136.215", port: "8080",
+		},
+		{
+			name: "plain literal",
+			js:   `document.write("45.77.136.215")`,
+			ip:   "45.77.136.215",
+		},
+	})
+}
@@ -0,0 +1,112 @@
+package proxyscrape
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// FailureReason says why a proxy failed its check, to tell a dead proxy
+// apart from a flaky check endpoint.
+type FailureReason int
+
+const (
+	FailureNone        FailureReason = iota
+	FailureInvalid                   // unusable address; never dialed
+	FailureTimeout                   // no answer within the timeout
+	FailureRefused                   // nothing listening on the proxy port
+	FailureReset                     // connection dropped mid-request
+	FailureDNS                       // the proxy couldn't resolve the check host
+	FailureTLS                       // TLS handshake or certificate error
+	FailureProxy                     // proxy refused the CONNECT or SOCKS handshake
+	FailureBadGateway                // proxy answered 502, 503 or 504
+	FailureStatus                    // check URL answered an unexpected status
+	FailureBody                      // response lacked the expected content
+	FailureUnconfirmed               // passed once, then failed a confirmation check
+	FailureOther
+)
+
+func (r FailureReason) String() string {
+	switch r {
+	case FailureInvalid:
+		return "invalid"
+	case FailureTimeout:
+		return "timeout"
+	case FailureRefused:
+		return "connection_refused"
+	case FailureReset:
+		return "connection_reset"
+	case FailureDNS:
+		return "dns"
+	case FailureTLS:
+		return "tls"
+	case FailureProxy:
+		return "proxy_error"
+	case FailureBadGateway:
+		return "bad_gateway"
+	case FailureStatus:
+		return "bad_status"
+	case FailureBody:
+		return "unexpected_body"
+	case FailureUnconfirmed:
+		return "unconfirmed"
+	case FailureOther:
+		return "other"
+	}
+	return ""
+}
+
+// classifyError maps an error from a request made through a proxy to a
+// FailureReason.
+func classifyError(err error) FailureReason {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var opErr *net.OpError
+
+	switch {
+	case err == nil:
+		return FailureNone
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return FailureReset
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr):
+		return FailureTLS
+	case errors.As(err, &opErr) && (opErr.Op == "proxyconnect" || strings.HasPrefix(opErr.Op, "socks ")),
+		errors.Is(err, errSOCKS4):
+		// x/net/proxy wraps SOCKS5 handshake failures in a net.OpError
+		// whose Op is "socks connect"; the SOCKS4 dialer wraps errSOCKS4.
+		return FailureProxy
+	case strings.Contains(err.Error(), "socks connect tcp"):
+		// Last resort, for a SOCKS error that lost its net.OpError to a
+		// wrapper that formats it as text rather than wrapping it. This is
+		// x/net/proxy's message, not merely any mention of socks.
+		return FailureProxy
+	}
+	return FailureOther
+}
+
+// classifyStatus maps an unexpected response status to a FailureReason.
+func classifyStatus(code int) FailureReason {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return FailureBadGateway
+	}
+	return FailureStatus
+}
@@ -0,0 +1,135 @@
+package proxyscrape
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// timeoutError is a net.Error that timed out, like a dial or read
+// deadline expiring.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// viaClient wraps err the way http.Client.Do returns transport errors.
+func viaClient(err error) error {
+	return &url.Error{Op: "Get", URL: "http://check.test/", Err: err}
+}
+
+func TestClassifyError(t *testing.T) {
+	dial := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want FailureReason
+	}{
+		{"nil", nil, FailureNone},
+		{"deadline", viaClient(context.DeadlineExceeded), FailureTimeout},
+		{"net timeout", viaClient(&net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}), FailureTimeout},
+		{"refused", viaClient(dial(syscall.ECONNREFUSED)), FailureRefused},
+		{"reset", viaClient(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), FailureReset},
+		{"eof", viaClient(io.EOF), FailureReset},
+		{"unexpected eof", viaClient(fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF)), FailureReset},
+		{"dns", viaClient(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "check.test"}}), FailureDNS},
+		{"unknown authority", viaClient(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), FailureTLS},
+		{"hostname mismatch", viaClient(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "check.test"}), FailureTLS},
+		{"record header", viaClient(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), FailureTLS},
+		{"alert", viaClient(tls.AlertError(40)), FailureTLS},
+		{"proxyconnect", viaClient(&net.OpError{Op: "proxyconnect", Net: "tcp", Err: errors.New("Forbidden")}), FailureProxy},
+		{"socks5", viaClient(&net.OpError{Op: "socks connect", Net: "tcp", Err: errors.New("unknown error general SOCKS server failure")}), FailureProxy},
+		{"socks5 as text", viaClient(fmt.Errorf("dialing: %v", &net.OpError{Op: "socks connect", Net: "tcp", Err: errors.New("unknown error host unreachable")})), FailureProxy},
+		{"socks4 rejected", viaClient(fmt.Errorf("%w: request rejected (code 91)", errSOCKS4)), FailureProxy},
+		{"socks in the target", viaClient(errors.New(`parsing "http://socks.example/": unexpected content`)), FailureOther},
+		{"other", viaClient(errors.New("net/http: HTTP/1.x transport connection broken: malformed HTTP response")), FailureOther},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("%s: classifyError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyStatus(t *testing.T) {
+	for code, want := range map[int]FailureReason{
+		http.StatusBadGateway:          FailureBadGateway,
+		http.StatusServiceUnavailable:  FailureBadGateway,
+		http.StatusGatewayTimeout:      FailureBadGateway,
+		http.StatusForbidden:           FailureStatus,
+		http.StatusProxyAuthRequired:   FailureStatus,
+		http.StatusNotFound:            FailureStatus,
+		http.StatusInternalServerError: FailureStatus,
+	} {
+		if got := classifyStatus(code); got != want {
+			t.Errorf("classifyStatus(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestFailureReasonString(t *testing.T) {
+	seen := make(map[string]FailureReason)
+	for r := FailureInvalid; r <= FailureOther; r++ {
+		s := r.String()
+		if s == "" {
+			t.Errorf("FailureReason(%d) has no name", r)
+		}
+		if prev, dup := seen[s]; dup {
+			t.Errorf("FailureReason(%d) and FailureReason(%d) are both %q", prev, r, s)
+		}
+		seen[s] = r
+	}
+	if s := FailureNone.String(); s != "" {
+		t.Errorf("FailureNone.String() = %q, want empty", s)
+	}
+}
+
+// TestClassifyErrorLive classifies errors from real requests, not just
+// errors built to look like them.
+func TestClassifyErrorLive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+	_, err = http.Get("http://" + closedAddr + "/")
+	if got := classifyError(err); got != FailureRefused {
+		t.Errorf("request to a closed port: classifyError(%v) = %v, want %v", err, got, FailureRefused)
+	}
+
+	hangup, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hangup.Close()
+	go func() {
+		for {
+			c, err := hangup.Accept()
+			if err != nil {
+				return
+			}
+			// read the request first, or the client may take the close
+			// for an idle connection going away
+			http.ReadRequest(bufio.NewReader(c))
+			c.Close()
+		}
+	}()
+	_, err = http.Get("http://" + hangup.Addr().String() + "/")
+	if got := classifyError(err); got != FailureReset {
+		t.Errorf("request to a server that hangs up: classifyError(%v) = %v, want %v", err, got, FailureReset)
+	}
+}
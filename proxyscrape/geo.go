@@ -0,0 +1,128 @@
+package proxyscrape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// geoLookupURL resolves an IP to its ISO country code. The free endpoint is
+// rate limited to 45 requests a minute, so lookups are cached and only made
+// for proxies whose source didn't already tell us the country.
+const geoLookupURL = "http://ip-api.com/json/%s?fields=status,message,countryCode"
+
+// geoLookupsPerMinute keeps lookups under the endpoint's limit, leaving a
+// little room for the clock of its window not lining up with ours.
+const geoLookupsPerMinute = 40
+
+// errNoCountry marks a lookup the endpoint answered without a country, as
+// it does for private and reserved addresses. Asking again won't help.
+var errNoCountry = errors.New("no country for address")
+
+// geoCache memoizes country lookups per IP for the lifetime of the run.
+type geoCache struct {
+	mu       sync.Mutex
+	entries  map[string]*geoEntry
+	url      string // geoLookupURL, or a test server's
+	limiter  *rate.Limiter
+	resolver *net.Resolver // nil for the system resolver
+}
+
+type geoEntry struct {
+	mu      sync.Mutex // held while looking up, so each IP is looked up once
+	done    bool
+	country string
+	err     error
+}
+
+func newGeoCache(resolver *net.Resolver) *geoCache {
+	return &geoCache{
+		entries:  make(map[string]*geoEntry),
+		url:      geoLookupURL,
+		limiter:  rate.NewLimiter(rate.Every(time.Minute/geoLookupsPerMinute), 1),
+		resolver: resolver,
+	}
+}
+
+// Country returns the ISO 3166-1 alpha-2 code for ip. Concurrent calls for
+// the same IP share a single request, and lookups wait their turn so as to
+// stay under the endpoint's rate limit. Only answers are cached, a country
+// or errNoCountry; a lookup that failed otherwise, say with a network error
+// or a 429, is made again on the next call.
+func (c *geoCache) Country(ctx context.Context, ip string) (string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[ip]
+	if !ok {
+		e = &geoEntry{}
+		c.entries[ip] = e
+	}
+	c.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return e.country, e.err
+	}
+	if err := c.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	country, err := lookupCountry(ctx, c.url, ip, c.resolver)
+	if err == nil || errors.Is(err, errNoCountry) {
+		e.done, e.country, e.err = true, country, err
+	}
+	return country, err
+}
+
+// lookupCountry asks the endpoint at lookupURL, a format for the IP, for
+// ip's country code.
+func lookupCountry(ctx context.Context, lookupURL, ip string, resolver *net.Resolver) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(lookupURL, ip), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := directClient(resolver).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("geo lookup for %s: status %d", ip, resp.StatusCode)
+	}
+
+	var result struct {
+		Status      string `json:"status"`
+		Message     string `json:"message"`
+		CountryCode string `json:"countryCode"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.Status != "success" {
+		return "", fmt.Errorf("geo lookup for %s failed: %s: %w", ip, result.Message, errNoCountry)
+	}
+	return strings.ToUpper(result.CountryCode), nil
+}
+
+// ParseCountries turns a comma-separated list of ISO codes into a set.
+func ParseCountries(list string) (map[string]bool, error) {
+	countries := make(map[string]bool)
+	for _, code := range strings.Split(list, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if len(code) != 2 {
+			return nil, fmt.Errorf("invalid country code %q (want ISO 3166-1 alpha-2, e.g. US)", code)
+		}
+		countries[code] = true
+	}
+	return countries, nil
+}
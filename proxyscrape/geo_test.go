@@ -0,0 +1,76 @@
+package proxyscrape
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+// testGeoCache returns a cache looking up countries with handler, without
+// a rate limit, and a count of the lookups made.
+func testGeoCache(t *testing.T, handler http.HandlerFunc) (*geoCache, *atomic.Int64) {
+	t.Helper()
+	var lookups atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	c := newGeoCache(nil)
+	c.url = srv.URL + "/json/%s"
+	c.limiter = rate.NewLimiter(rate.Inf, 1)
+	return c, &lookups
+}
+
+func TestGeoCacheRetriesFailedLookup(t *testing.T) {
+	var limited atomic.Bool
+	limited.Store(true)
+	c, lookups := testGeoCache(t, func(w http.ResponseWriter, r *http.Request) {
+		if limited.Load() {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.WriteString(w, `{"status":"success","countryCode":"de"}`)
+	})
+	ctx := context.Background()
+
+	if country, err := c.Country(ctx, "1.2.3.4"); err == nil {
+		t.Fatalf("Country answered with 429 = %q, want an error", country)
+	}
+	limited.Store(false)
+	if country, err := c.Country(ctx, "1.2.3.4"); err != nil || country != "DE" {
+		t.Errorf("Country after a 429 = %q, %v; want DE", country, err)
+	}
+	if country, err := c.Country(ctx, "1.2.3.4"); err != nil || country != "DE" {
+		t.Errorf("cached Country = %q, %v; want DE", country, err)
+	}
+	if n := lookups.Load(); n != 2 {
+		t.Errorf("made %d lookups, want 2: the failed one and its retry", n)
+	}
+}
+
+func TestGeoCacheKeepsNoCountry(t *testing.T) {
+	c, lookups := testGeoCache(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"status":"fail","message":"private range"}`)
+	})
+	for range 2 {
+		if _, err := c.Country(context.Background(), "10.0.0.1"); !errors.Is(err, errNoCountry) {
+			t.Errorf("Country of a private address = %v, want errNoCountry", err)
+		}
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("made %d lookups for an address without a country, want 1", n)
+	}
+}
+
+func TestGeoCacheRateLimit(t *testing.T) {
+	if perMinute := float64(newGeoCache(nil).limiter.Limit()) * 60; perMinute > 45 {
+		t.Errorf("geo lookups allowed at %.0f a minute, more than the endpoint's 45", perMinute)
+	}
+}
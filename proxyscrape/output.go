@@ -0,0 +1,453 @@
+package proxyscrape
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFormats lists the formats WriteProxies understands.
+var OutputFormats = []string{"txt", "json", "csv", "proxychains", "md", "env", "clash"}
+
+// WriteProxies writes proxies to w in the given format, defaulting to txt.
+func WriteProxies(w io.Writer, format string, proxies []Proxy) error {
+	switch format {
+	case "json":
+		return writeJSON(w, proxies)
+	case "csv":
+		return writeCSV(w, proxies)
+	case "proxychains":
+		return writeProxychains(w, proxies)
+	case "md":
+		return writeMarkdown(w, proxies)
+	case "env":
+		return writeEnv(w, proxies)
+	case "clash":
+		return writeClash(w, proxies)
+	default:
+		return writeTXT(w, proxies)
+	}
+}
+
+func writeTXT(w io.Writer, proxies []Proxy) error {
+	for _, proxy := range proxies {
+		if _, err := io.WriteString(w, proxy.String()+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAnnotated writes proxies in the txt format, following each line
+// with a comment of what validation learned about the proxy, e.g.
+// "http://1.2.3.4:8080 # country=US latency=230ms anon=elite". Tools that
+// read only the first field ignore it.
+func WriteAnnotated(w io.Writer, proxies []Proxy) error {
+	for _, proxy := range proxies {
+		if _, err := io.WriteString(w, annotatedLine(proxy)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// annotatedLine returns proxy's txt line with a comment of the country,
+// latency and anonymity level it has, if any.
+func annotatedLine(proxy Proxy) string {
+	var notes []string
+	if proxy.Country != "" {
+		notes = append(notes, "country="+proxy.Country)
+	}
+	if proxy.Latency > 0 {
+		notes = append(notes, "latency="+proxy.Latency.Round(time.Millisecond).String())
+	}
+	if anon := proxy.Anonymity.String(); anon != "" {
+		notes = append(notes, "anon="+anon)
+	}
+	if len(notes) == 0 {
+		return proxy.String()
+	}
+	return proxy.String() + " # " + strings.Join(notes, " ")
+}
+
+// writeProxychains writes a proxychains-ng [ProxyList] block, one
+// "type host port [user pass]" line per proxy.
+func writeProxychains(w io.Writer, proxies []Proxy) error {
+	if _, err := io.WriteString(w, "[ProxyList]\n"); err != nil {
+		return err
+	}
+	for _, proxy := range proxies {
+		if _, err := io.WriteString(w, proxychainsLine(proxy)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func proxychainsLine(proxy Proxy) string {
+	line := proxychainsType(proxy.Protocol) + " " + proxy.IP + " " + proxy.Port
+	if proxy.Username != "" {
+		line += " " + proxy.Username + " " + proxy.Password
+	}
+	return line
+}
+
+// proxychainsType maps a proxy scheme to proxychains' type column.
+func proxychainsType(protocol string) string {
+	switch protocol = strings.ToLower(protocol); protocol {
+	case "socks4", "socks5":
+		return protocol
+	default:
+		return "http"
+	}
+}
+
+// writeMarkdown writes a GitHub-flavored Markdown table. The Country,
+// Latency and Anonymity columns only appear if some proxy has a value.
+func writeMarkdown(w io.Writer, proxies []Proxy) error {
+	type column struct {
+		name  string
+		value func(Proxy) string
+	}
+	columns := []column{
+		{"Proxy", func(p Proxy) string { return net.JoinHostPort(p.IP, p.Port) }},
+		{"Protocol", func(p Proxy) string { return p.Protocol }},
+	}
+	optional := []column{
+		{"Country", func(p Proxy) string { return p.Country }},
+		{"Latency", func(p Proxy) string {
+			if p.Latency == 0 {
+				return ""
+			}
+			return p.Latency.Round(time.Millisecond).String()
+		}},
+		{"Anonymity", func(p Proxy) string { return p.Anonymity.String() }},
+	}
+	for _, col := range optional {
+		if slices.ContainsFunc(proxies, func(p Proxy) bool { return col.value(p) != "" }) {
+			columns = append(columns, col)
+		}
+	}
+
+	header := make([]string, len(columns))
+	rule := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.name
+		rule[i] = "---"
+	}
+	lines := []string{
+		"| " + strings.Join(header, " | ") + " |",
+		"| " + strings.Join(rule, " | ") + " |",
+	}
+	if _, err := io.WriteString(w, strings.Join(lines, "\n")+"\n"); err != nil {
+		return err
+	}
+	row := make([]string, len(columns))
+	for _, proxy := range proxies {
+		for i, col := range columns {
+			row[i] = col.value(proxy)
+		}
+		if _, err := io.WriteString(w, "| "+strings.Join(row, " | ")+" |\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEnv writes shell export lines pointing HTTP_PROXY and HTTPS_PROXY,
+// and their lowercase spellings, at the fastest proxy, for use with
+// eval "$(proxyScrape -format env)". HTTPS_PROXY prefers the fastest proxy
+// known to tunnel HTTPS.
+func writeEnv(w io.Writer, proxies []Proxy) error {
+	httpProxy, ok := fastest(proxies, func(Proxy) bool { return true })
+	if !ok {
+		_, err := io.WriteString(w, "# no live proxies\n")
+		return err
+	}
+	httpsProxy, ok := fastest(proxies, func(p Proxy) bool { return p.HTTPSOK })
+	if !ok {
+		httpsProxy = httpProxy
+	}
+	for _, v := range []struct{ name, value string }{
+		{"HTTP_PROXY", httpProxy.String()},
+		{"HTTPS_PROXY", httpsProxy.String()},
+		{"http_proxy", httpProxy.String()},
+		{"https_proxy", httpsProxy.String()},
+	} {
+		if _, err := io.WriteString(w, "export "+v.name+"="+shellQuote(v.value)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fastest returns the proxy with the lowest measured latency of those keep
+// accepts, or the first it accepts if none has one.
+func fastest(proxies []Proxy, keep func(Proxy) bool) (Proxy, bool) {
+	var best Proxy
+	found := false
+	for _, p := range proxies {
+		if !keep(p) {
+			continue
+		}
+		if !found || (p.Latency > 0 && (best.Latency == 0 || p.Latency < best.Latency)) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// clashProxy is an entry of a Clash config's proxies list.
+type clashProxy struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Server   string `yaml:"server"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	TLS      bool   `yaml:"tls,omitempty"`
+}
+
+// clashEntry returns proxy's entry in a Clash proxies list, indented to sit
+// under the "proxies:" key, or "" if Clash can't use its protocol. Names
+// are derived from the protocol, IP and port, so they're the same every
+// run.
+func clashEntry(proxy Proxy) (string, error) {
+	protocol := strings.ToLower(proxy.Protocol)
+	entry := clashProxy{Type: protocol, Server: proxy.IP, Username: proxy.Username, Password: proxy.Password}
+	switch protocol {
+	case "http", "socks5":
+	case "https":
+		entry.Type, entry.TLS = "http", true
+	default:
+		return "", nil // Clash has no socks4
+	}
+	entry.Name = protocol + "-" + proxy.IP + "-" + proxy.Port
+	entry.Port, _ = strconv.Atoi(proxy.Port)
+	b, err := yaml.Marshal([]clashProxy{entry})
+	if err != nil {
+		return "", err
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(b), "\n"), "\n")
+	return "  " + strings.Join(lines, "  ") + "\n", nil
+}
+
+// writeClash writes a Clash config fragment listing the proxies Clash
+// supports, http, https and socks5, to be merged into a full config or
+// used as a proxy provider.
+func writeClash(w io.Writer, proxies []Proxy) error {
+	var b strings.Builder
+	for _, proxy := range proxies {
+		entry, err := clashEntry(proxy)
+		if err != nil {
+			return err
+		}
+		b.WriteString(entry)
+	}
+	if b.Len() == 0 {
+		_, err := io.WriteString(w, "proxies: []\n")
+		return err
+	}
+	_, err := io.WriteString(w, "proxies:\n"+b.String())
+	return err
+}
+
+type jsonProxy struct {
+	IP        string `json:"ip"`
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	Source    string `json:"source"`
+	Country   string `json:"country,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Anonymity string `json:"anonymity,omitempty"`
+	HTTPOK    bool   `json:"http_ok"`
+	HTTPSOK   bool   `json:"https_ok"`
+	TargetOK  bool   `json:"target_ok"`
+}
+
+func newJSONProxy(proxy Proxy) jsonProxy {
+	port, _ := strconv.Atoi(proxy.Port)
+	return jsonProxy{
+		IP:        proxy.IP,
+		Port:      port,
+		Protocol:  proxy.Protocol,
+		Source:    proxy.Source,
+		Country:   proxy.Country,
+		LatencyMs: proxy.Latency.Milliseconds(),
+		Anonymity: proxy.Anonymity.String(),
+		HTTPOK:    proxy.HTTPOK,
+		HTTPSOK:   proxy.HTTPSOK,
+		TargetOK:  proxy.TargetOK,
+	}
+}
+
+// writeJSON streams the proxies as a JSON array one element at a time so
+// large result sets are never held in memory as a single encoded blob.
+func writeJSON(w io.Writer, proxies []Proxy) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, proxy := range proxies {
+		b, err := json.Marshal(newJSONProxy(proxy))
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if i == 0 {
+			sep = "\n"
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+var csvHeader = []string{"ip", "port", "protocol", "source", "latency_ms"}
+
+func writeCSV(w io.Writer, proxies []Proxy) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, proxy := range proxies {
+		cw.Write(csvRecord(proxy))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvRecord(proxy Proxy) []string {
+	return []string{
+		proxy.IP,
+		proxy.Port,
+		proxy.Protocol,
+		proxy.Source,
+		strconv.FormatInt(proxy.Latency.Milliseconds(), 10),
+	}
+}
+
+// StreamFormats lists the formats a ProxyStream can write. Markdown is
+// missing since its columns depend on every proxy, and env since it picks
+// the fastest.
+var StreamFormats = []string{"txt", "json", "csv", "proxychains", "clash"}
+
+// ProxyStream writes proxies one at a time as they're found, producing the
+// same output WriteProxies would for the whole list. Close finishes the
+// output and must be called even if nothing was written.
+type ProxyStream struct {
+	// Annotate makes the txt format write lines as WriteAnnotated does.
+	Annotate bool
+
+	w       io.Writer
+	format  string
+	started bool
+	n       int
+	csv     *csv.Writer
+	listed  bool // the clash format's "proxies:" key is written
+}
+
+// NewProxyStream returns a ProxyStream writing format, one of
+// StreamFormats, to w. Each proxy is written to w as soon as it is given.
+func NewProxyStream(w io.Writer, format string) *ProxyStream {
+	return &ProxyStream{w: w, format: format}
+}
+
+// start writes the format's header, once.
+func (s *ProxyStream) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	switch s.format {
+	case "json":
+		_, err := io.WriteString(s.w, "[")
+		return err
+	case "csv":
+		s.csv = csv.NewWriter(s.w)
+		s.csv.Write(csvHeader)
+		s.csv.Flush()
+		return s.csv.Error()
+	case "proxychains":
+		_, err := io.WriteString(s.w, "[ProxyList]\n")
+		return err
+	}
+	return nil
+}
+
+// Write writes proxy.
+func (s *ProxyStream) Write(proxy Proxy) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	s.n++
+	switch s.format {
+	case "json":
+		b, err := json.Marshal(newJSONProxy(proxy))
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if s.n == 1 {
+			sep = "\n"
+		}
+		_, err = io.WriteString(s.w, sep+string(b))
+		return err
+	case "csv":
+		s.csv.Write(csvRecord(proxy))
+		s.csv.Flush()
+		return s.csv.Error()
+	case "proxychains":
+		_, err := io.WriteString(s.w, proxychainsLine(proxy)+"\n")
+		return err
+	case "clash":
+		entry, err := clashEntry(proxy)
+		if err != nil || entry == "" {
+			return err
+		}
+		if !s.listed {
+			s.listed = true
+			entry = "proxies:\n" + entry
+		}
+		_, err = io.WriteString(s.w, entry)
+		return err
+	default:
+		line := proxy.String()
+		if s.Annotate {
+			line = annotatedLine(proxy)
+		}
+		_, err := io.WriteString(s.w, line+"\n")
+		return err
+	}
+}
+
+// Close writes whatever the format needs after the last proxy. It doesn't
+// close the underlying writer.
+func (s *ProxyStream) Close() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	switch {
+	case s.format == "json":
+		_, err := io.WriteString(s.w, "\n]\n")
+		return err
+	case s.format == "clash" && !s.listed:
+		_, err := io.WriteString(s.w, "proxies: []\n")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,479 @@
+package proxyscrape
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// A Parser extracts proxies from the body of a fetched source.
+type Parser interface {
+	// Name identifies the kind of parser: "table", "json", "text" or
+	// "custom". The site a proxy came from is its Source, not this.
+	Name() string
+	// Parse sends every proxy found in body to out and returns how many
+	// it sent, along with the page's "next" link if it has one. The link
+	// may be relative to the fetched page.
+	Parse(source string, body io.Reader, out chan<- Proxy) (n int, next string, err error)
+}
+
+// pagedParser is implemented by parsers whose sources span several pages.
+type pagedParser interface {
+	Parser
+	// PageURL returns the URL of the given 1-based page of source.
+	PageURL(source string, page int) string
+}
+
+// formParser is implemented by parsers whose sources only serve the full
+// list in answer to a form posted back within the session a first GET
+// starts.
+type formParser interface {
+	Parser
+	// Form returns the fields to post, read from the page the GET returned.
+	Form(page io.Reader) (url.Values, error)
+}
+
+// sourceParsers maps a source host to its dedicated parser. Hosts not
+// listed here fall back to the generic table parser.
+var sourceParsers = map[string]Parser{
+	"www.proxynova.com":       proxynovaParser{},
+	"proxylist.geonode.com":   geonodeParser{},
+	"www.proxy-list.download": textParser{},
+	proxyscrapeAPIHost:        textParser{},
+	"spys.one":                spysParser{},
+}
+
+// ParserName returns the kind of parser source's pages are read with, as
+// its Name.
+func ParserName(source string) string {
+	return parserFor(source).Name()
+}
+
+// parserFor returns the parser registered for source's host.
+func parserFor(source string) Parser {
+	if u, err := url.Parse(source); err == nil {
+		if p, ok := sourceParsers[strings.ToLower(u.Host)]; ok {
+			return p
+		}
+	}
+	return tableParser{}
+}
+
+// countryCode matches the ISO code column some list tables carry.
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// tableParser handles the common "table tbody tr" layout, locating the IP,
+// port, country code, anonymity and protocol columns from the table header
+// (as on free-proxy-list.net and its sister sites) or, without one, taking
+// the IP from the first column, port from the second, country code from
+// the third and protocol from the fifth. Rows without a valid IP and port
+// are skipped. It also picks up any document.write scripts that hide an IP.
+type tableParser struct{}
+
+func (tableParser) Name() string { return "table" }
+
+// tableColumns gives the index of each field's cell in a row; -1 means
+// the table has no such column.
+type tableColumns struct {
+	ip, port, code, anonymity, protocol int
+}
+
+var defaultColumns = tableColumns{ip: 0, port: 1, code: 2, anonymity: -1, protocol: 4}
+
+// columnsFor maps a table's header cells to fields, falling back to
+// defaultColumns when the header doesn't name both an IP and a port column.
+func columnsFor(table *goquery.Selection) tableColumns {
+	headers := table.Find("thead th")
+	if headers.Length() == 0 {
+		headers = table.Find("tr").First().Find("th")
+	}
+	cols := tableColumns{ip: -1, port: -1, code: -1, anonymity: -1, protocol: -1}
+	headers.Each(func(i int, th *goquery.Selection) {
+		switch strings.ToLower(cellText(th)) {
+		case "ip", "ip address", "proxy ip":
+			cols.ip = i
+		case "port":
+			cols.port = i
+		case "code", "country code":
+			cols.code = i
+		case "anonymity", "anonymity level":
+			cols.anonymity = i
+		case "protocol", "version", "type":
+			cols.protocol = i
+		}
+	})
+	if cols.ip < 0 || cols.port < 0 {
+		return defaultColumns
+	}
+	return cols
+}
+
+// column returns the text of cells' i'th cell, or "" if i is -1.
+func column(cells *goquery.Selection, i int) string {
+	if i < 0 {
+		return ""
+	}
+	return cellText(cells.Eq(i))
+}
+
+// tableAnonymity reads an anonymity cell such as "elite proxy" or
+// "anonymous".
+func tableAnonymity(s string) Anonymity {
+	switch s = strings.ToLower(s); {
+	case strings.Contains(s, "elite"), strings.Contains(s, "high anonym"):
+		return Elite
+	case strings.Contains(s, "anonym"):
+		return Anonymous
+	case strings.Contains(s, "transparent"):
+		return Transparent
+	}
+	return AnonymityUnknown
+}
+
+func (tableParser) Parse(source string, body io.Reader, out chan<- Proxy) (int, string, error) {
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var n int
+	doc.Find("table").Each(func(_ int, table *goquery.Selection) {
+		cols := columnsFor(table)
+		table.ChildrenFiltered("tbody").ChildrenFiltered("tr").Each(func(_ int, row *goquery.Selection) {
+			cells := row.Find("td")
+			ip := compactText(cells.Eq(cols.ip))
+			port := compactText(cells.Eq(cols.port))
+			if !isValidIP(ip) || !isValidPort(port) {
+				return // ads, notes and spacer rows
+			}
+
+			proxy := Proxy{IP: ip, Port: port, Protocol: "http", Source: source}
+			if code := column(cells, cols.code); countryCode.MatchString(code) {
+				proxy.Country = code
+			}
+			proxy.Anonymity = tableAnonymity(column(cells, cols.anonymity))
+			switch protocol := strings.ToLower(column(cells, cols.protocol)); {
+			case strings.Contains(protocol, "socks5"):
+				proxy.Protocol = "socks5"
+			case strings.Contains(protocol, "socks4"):
+				proxy.Protocol = "socks4"
+			}
+			out <- proxy
+			n++
+		})
+	})
+
+	doc.Find("script").Each(func(_ int, s *goquery.Selection) {
+		js := s.Text()
+		if strings.Contains(js, "document.write") {
+			// a script on its own gives us no port column to fall back on
+			if ip, port := deobfuscateAddr(js); ip != "" && port != "" {
+				out <- Proxy{IP: ip, Port: port, Protocol: "http", Source: source}
+				n++
+			}
+		}
+	})
+	return n, nextLink(doc), nil
+}
+
+// cellText returns the visible text of a table cell with runs of
+// whitespace, including non-breaking spaces, collapsed to one space and
+// the ends trimmed. Elements hidden with an inline display:none, a common
+// decoy, are skipped.
+func cellText(cell *goquery.Selection) string {
+	cell = cell.Clone()
+	cell.Find("[style]").FilterFunction(func(_ int, el *goquery.Selection) bool {
+		style, _ := el.Attr("style")
+		return strings.Contains(strings.ReplaceAll(strings.ToLower(style), " ", ""), "display:none")
+	}).Remove()
+	return strings.Join(strings.Fields(cell.Text()), " ")
+}
+
+// compactText is cellText with all whitespace removed, for IP and port
+// cells whose value never contains any.
+func compactText(cell *goquery.Selection) string {
+	return strings.ReplaceAll(cellText(cell), " ", "")
+}
+
+// proxynovaParser handles proxynova's table, where the IP cell is written
+// by an inline script rather than present as text.
+type proxynovaParser struct{}
+
+func (proxynovaParser) Name() string { return "table" }
+
+func (proxynovaParser) Parse(source string, body io.Reader, out chan<- Proxy) (int, string, error) {
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var n int
+	doc.Find("table#tbl_proxy_list tbody tr").Each(func(_ int, row *goquery.Selection) {
+		cells := row.Find("td")
+		ipCell := cells.Eq(0)
+
+		ip := compactText(ipCell)
+		port := compactText(cells.Eq(1))
+		if script := ipCell.Find("script"); script.Length() > 0 {
+			var scriptPort string
+			ip, scriptPort = deobfuscateAddr(script.Text())
+			if scriptPort != "" {
+				port = scriptPort
+			}
+		} else if title, ok := ipCell.Find("abbr").Attr("title"); ok {
+			ip = strings.TrimSpace(title)
+		}
+		if !isValidIP(ip) || !isValidPort(port) {
+			return
+		}
+		out <- Proxy{IP: ip, Port: port, Protocol: "http", Source: source}
+		n++
+	})
+	return n, nextLink(doc), nil
+}
+
+// nextLinkSelectors find a pagination "next" link, most specific first.
+var nextLinkSelectors = []string{
+	`a[rel~="next"]`,
+	`link[rel~="next"]`,
+	`.pagination .next a`,
+	`.pagination a.next`,
+	`li.next a`,
+}
+
+// nextLink returns the href of doc's "next page" link, or "" if the page
+// has no pagination element.
+func nextLink(doc *goquery.Document) string {
+	for _, sel := range nextLinkSelectors {
+		if href, ok := doc.Find(sel).First().Attr("href"); ok && strings.TrimSpace(href) != "" {
+			return strings.TrimSpace(href)
+		}
+	}
+	var next string
+	doc.Find(".pagination a, nav a").EachWithBreak(func(_ int, a *goquery.Selection) bool {
+		switch strings.ToLower(strings.TrimSpace(a.Text())) {
+		case "next", "next »", "»", "›", ">":
+			next, _ = a.Attr("href")
+			next = strings.TrimSpace(next)
+			return next == ""
+		}
+		return true
+	})
+	return next
+}
+
+// geonodeParser reads geonode's JSON API, which reports protocol, country,
+// anonymity and latency for every proxy. Entries without a valid IP and
+// port are skipped.
+type geonodeParser struct{}
+
+func (geonodeParser) Name() string { return "json" }
+
+func (geonodeParser) PageURL(source string, page int) string {
+	u, err := url.Parse(source)
+	if err != nil {
+		return source
+	}
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// geonodeEntry is one proxy in a geonode API response.
+type geonodeEntry struct {
+	IP             string      `json:"ip"`
+	Port           json.Number `json:"port"` // sometimes quoted
+	Protocols      []string    `json:"protocols"`
+	Country        string      `json:"country"`
+	AnonymityLevel string      `json:"anonymityLevel"`
+	Latency        json.Number `json:"latency"`      // milliseconds
+	ResponseTime   json.Number `json:"responseTime"` // milliseconds, in older responses
+}
+
+func (geonodeParser) Parse(source string, body io.Reader, out chan<- Proxy) (int, string, error) {
+	var page struct {
+		Data []json.RawMessage `json:"data"` // decoded one by one, so a malformed entry is only skipped
+	}
+	if err := json.NewDecoder(body).Decode(&page); err != nil {
+		return 0, "", err
+	}
+
+	var n int
+	for _, raw := range page.Data {
+		var entry geonodeEntry
+		if err := json.Unmarshal(raw, &entry); err != nil || !isValidIP(entry.IP) || !isValidPort(entry.Port.String()) {
+			continue
+		}
+		anonymity, _ := ParseAnonymity(entry.AnonymityLevel)
+		var latency time.Duration
+		if ms, err := cmp.Or(entry.Latency, entry.ResponseTime).Float64(); err == nil && ms > 0 {
+			latency = time.Duration(ms * float64(time.Millisecond))
+		}
+		seen := make(map[string]bool)
+		for _, protocol := range entry.Protocols {
+			// geonode lists https separately, but it's the same http proxy
+			protocol = strings.ToLower(protocol)
+			if protocol == "https" {
+				protocol = "http"
+			}
+			if seen[protocol] {
+				continue
+			}
+			seen[protocol] = true
+			out <- Proxy{
+				IP:        entry.IP,
+				Port:      entry.Port.String(),
+				Protocol:  protocol,
+				Source:    source,
+				Country:   strings.ToUpper(entry.Country),
+				Anonymity: anonymity,
+				Latency:   latency,
+			}
+			n++
+		}
+	}
+	return n, "", nil
+}
+
+// textParser reads plain-text lists of ip:port lines, as served by the
+// proxy-list.download and proxyscrape.com APIs. The protocol comes from the
+// source URL's "type" or "protocol" query parameter, defaulting to http.
+type textParser struct{}
+
+func (textParser) Name() string { return "text" }
+
+func (textParser) Parse(source string, body io.Reader, out chan<- Proxy) (int, string, error) {
+	protocol := "http"
+	if u, err := url.Parse(source); err == nil {
+		switch t := strings.ToLower(cmp.Or(u.Query().Get("type"), u.Query().Get("protocol"))); t {
+		case "socks4", "socks5":
+			protocol = t
+		}
+	}
+
+	var n int
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(scanner.Text()))
+		if err != nil || !isValidIP(host) || !isValidPort(port) {
+			continue
+		}
+		out <- Proxy{IP: host, Port: port, Protocol: protocol, Source: source}
+		n++
+	}
+	return n, "", scanner.Err()
+}
+
+// spysParser handles spys.one, which answers a plain GET with a few rows
+// and lists up to 500 only when its form is posted back with the page's
+// xx0 session token. Each row's port is written by a script after the IP,
+// one digit per XOR of variables the page defines in an earlier script.
+type spysParser struct{}
+
+func (spysParser) Name() string { return "custom" }
+
+// Form asks for the largest page (xpp=5) with the anonymity, SSL, port and
+// type filters (xf1, xf2, xf4, xf5) all off.
+func (spysParser) Form(page io.Reader) (url.Values, error) {
+	doc, err := goquery.NewDocumentFromReader(page)
+	if err != nil {
+		return nil, err
+	}
+	token, ok := doc.Find(`input[name="xx0"]`).Attr("value")
+	if !ok || token == "" {
+		return nil, errors.New("no xx0 session token on the page")
+	}
+	return url.Values{
+		"xx0": {token},
+		"xpp": {"5"},
+		"xf1": {"0"},
+		"xf2": {"0"},
+		"xf4": {"0"},
+		"xf5": {"0"},
+	}, nil
+}
+
+// spysIP finds the IP in an address cell's text.
+var spysIP = regexp.MustCompile(`\d{1,3}(?:\.\d{1,3}){3}`)
+
+func (spysParser) Parse(source string, body io.Reader, out chan<- Proxy) (int, string, error) {
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var defs strings.Builder
+	doc.Find("script").Each(func(_ int, s *goquery.Selection) {
+		if js := s.Text(); !strings.Contains(js, "document.write") {
+			defs.WriteString(js + "\n")
+		}
+	})
+	vars := xorVars(defs.String())
+
+	var n int
+	doc.Find("tr.spy1x, tr.spy1xx").Each(func(_ int, row *goquery.Selection) {
+		cells := row.ChildrenFiltered("td")
+		addr := cells.Eq(0).Clone()
+		script := addr.Find("script").Remove()
+		ip := spysIP.FindString(addr.Text())
+		port := spysPort(script.Text(), vars)
+		if !isValidIP(ip) || !isValidPort(port) {
+			return
+		}
+
+		proxy := Proxy{IP: ip, Port: port, Protocol: "http", Source: source}
+		switch kind := strings.ToLower(cellText(cells.Eq(1))); {
+		case strings.Contains(kind, "socks5"):
+			proxy.Protocol = "socks5"
+		case strings.Contains(kind, "socks4"):
+			proxy.Protocol = "socks4"
+		}
+		proxy.Anonymity = spysAnonymity(cellText(cells.Eq(2)))
+		out <- proxy
+		n++
+	})
+	return n, "", nil
+}
+
+// spysAnonymity reads spys.one's anonymity codes: NOA (none), ANM
+// (anonymous) and HIA (high anonymity).
+func spysAnonymity(code string) Anonymity {
+	switch strings.ToUpper(code) {
+	case "NOA":
+		return Transparent
+	case "ANM":
+		return Anonymous
+	case "HIA":
+		return Elite
+	}
+	return AnonymityUnknown
+}
+
+// spysPort returns the port a row's script writes after the ":" separator,
+// or "" if the script can't be evaluated with vars.
+func spysPort(js string, vars map[string]int) string {
+	written, ok := evalXORWrite(js, vars)
+	if !ok {
+		return ""
+	}
+	_, port, _ := strings.Cut(stripTags(written), ":")
+	return strings.TrimSpace(port)
+}
+
+// htmlTag matches an HTML tag, for stripping markup out of written text.
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+func stripTags(s string) string {
+	return htmlTag.ReplaceAllString(s, "")
+}
@@ -0,0 +1,139 @@
+package proxyscrape
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// messyTablePage pads and hides things in cells the way list sites do:
+// newlines and indentation, non-breaking spaces as text and as entities,
+// decoy digits in display:none spans, and rows that hold no proxy at all.
+const messyTablePage = "<table><thead><tr><th>IP Address</th><th>Port</th><th>Code</th><th>Protocol</th></tr></thead><tbody>\n" +
+	"<tr><td>\n\t\t1.2.3.4\n\t</td><td> 8080\n</td><td>US</td><td>HTTP</td></tr>\n" +
+	"<tr><td>&nbsp;5.6.7.8&nbsp;</td><td> 3128 </td><td> DE </td><td>\n socks5 \n</td></tr>\n" +
+	"<tr><td>9.10<span style=\"display: none\">.99</span>.11.12</td><td>10<span style=\"DISPLAY:NONE;\">9</span>80</td><td>FR</td><td>socks4</td></tr>\n" +
+	"<tr><td><span>13.14</span>.<span>15.16</span></td><td><b>80</b></td><td></td><td></td></tr>\n" +
+	"<tr><td colspan=\"4\">\n<ins class=\"adsbygoogle\"></ins>\n</td></tr>\n" +
+	"<tr><td>Showing 1 to 20</td><td>of 300</td><td></td><td></td></tr>\n" +
+	"<tr><td>1.2.3.999</td><td>8080</td><td></td><td></td></tr>\n" +
+	"<tr><td>17.18.19.20</td><td>99999</td><td></td><td></td></tr>\n" +
+	"<tr><td>21.22.23.24</td><td></td><td></td><td></td></tr>\n" +
+	"</tbody></table>"
+
+func TestTableParserMessyCells(t *testing.T) {
+	got := parseAll(t, tableParser{}, "https://example.com/list", messyTablePage)
+	assertProxies(t, got, []string{
+		"http://1.2.3.4:8080",
+		"socks5://5.6.7.8:3128",
+		"socks4://9.10.11.12:1080",
+		"http://13.14.15.16:80",
+	})
+	if len(got) > 1 && got[1].Country != "DE" {
+		t.Errorf("padded country cell gave %q, want DE", got[1].Country)
+	}
+}
+
+func TestTableParserDefaultColumns(t *testing.T) {
+	page := `<table><tbody>
+<tr><td> 1.2.3.4 </td><td>8080</td><td>US</td><td>United States</td><td>Socks5</td></tr>
+<tr><td>not an ip</td><td>8080</td><td>US</td><td></td><td></td></tr>
+</tbody></table>`
+	got := parseAll(t, tableParser{}, "https://example.com/list", page)
+	assertProxies(t, got, []string{"socks5://1.2.3.4:8080"})
+}
+
+// spysPage follows spys.one's markup: an early script defines the XOR
+// variables, and each row's address cell writes ":" and then the port one
+// digit per XOR of two of them.
+const spysPage = `<html><body>
+<form method="post" action="/en/free-proxy-list/"><input type="hidden" name="xx0" value="4b2f8c0d1e9a7b6c5d4e3f2a1b0c9d8e"></form>
+<script type="text/javascript">Nine3Zero=6305;Two8One=3471;Five4Seven=7468;Zero1Six=1791;Three9Eight=2186;Eight6Four=9779;One7Two=2542;Six5Nine=6991;Seven2Three=1950;Four0Five=9313;c1r7=0^Nine3Zero;x0u2=1^Zero1Six;j4t6=2^One7Two;q8s5=3^Four0Five;m3w1=4^Five4Seven;p9a0=5^Eight6Four;z6y4=6^Seven2Three;f2e8=7^Two8One;k7h3=8^Three9Eight;b5n9=9^Six5Nine;</script>
+<table>
+<tr class="spy1x"><td colspan="1"><font class="spy1">Proxy address:port</font></td><td><font class="spy1">Proxy type</font></td><td><font class="spy1">Anonymity</font></td></tr>
+<tr class="spy1xx" onmouseover="this.style.background='#002424'"><td colspan="1"><font class="spy14">190.61.88.147<script type="text/javascript">document.write("<font class=spy2>:<\/font>"+(k7h3^Three9Eight)+(c1r7^Nine3Zero)+(k7h3^Three9Eight)+(c1r7^Nine3Zero))</script></font></td><td colspan="1"><a href="/en/http-proxy-list/"><font class="spy1">HTTP</font></a></td><td colspan="1"><font class="spy1">HIA</font></td></tr>
+<tr class="spy1x" onmouseover="this.style.background='#002424'"><td colspan="1"><font class="spy14">103.155.217.1<script type="text/javascript">document.write("<font class=spy2>:<\/font>"+(q8s5^Four0Five)+(x0u2^Zero1Six)+(j4t6^One7Two)+(k7h3^Three9Eight))</script></font></td><td colspan="1"><font class="spy1">HTTPS</font> <font class="spy14">(Squid)</font></td><td colspan="1"><font class="spy1">NOA</font></td></tr>
+<tr class="spy1xx" onmouseover="this.style.background='#002424'"><td colspan="1"><font class="spy14">72.195.34.59<script type="text/javascript">document.write("<font class=spy2>:<\/font>"+(x0u2^Zero1Six)+(c1r7^Nine3Zero)+(k7h3^Three9Eight)+(c1r7^Nine3Zero))</script></font></td><td colspan="1"><font class="spy1">SOCKS5</font></td><td colspan="1"><font class="spy1">ANM</font></td></tr>
+<tr class="spy1x"><td colspan="1"><font class="spy14">51.15.242.202<script type="text/javascript">document.write("<font class=spy2>:<\/font>"+(k7h3^Undefined9Var))</script></font></td><td colspan="1"><font class="spy1">HTTP</font></td><td colspan="1"><font class="spy1">HIA</font></td></tr>
+</table></body></html>`
+
+func TestSpysParser(t *testing.T) {
+	got := parseAll(t, spysParser{}, "https://spys.one/en/free-proxy-list/", spysPage)
+	assertProxies(t, got, []string{
+		"http://190.61.88.147:8080",
+		"http://103.155.217.1:3128",
+		"socks5://72.195.34.59:1080",
+	})
+	want := []Anonymity{Elite, Transparent, Anonymous}
+	for i, proxy := range got {
+		if i < len(want) && proxy.Anonymity != want[i] {
+			t.Errorf("%s has anonymity %v, want %v", proxy, proxy.Anonymity, want[i])
+		}
+	}
+}
+
+func TestSpysForm(t *testing.T) {
+	form, err := spysParser{}.Form(strings.NewReader(spysPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := form.Get("xx0"); got != "4b2f8c0d1e9a7b6c5d4e3f2a1b0c9d8e" {
+		t.Errorf("form xx0 = %q, want the page's session token", got)
+	}
+	if form.Get("xpp") != "5" {
+		t.Errorf("form xpp = %q, want 5 (the largest page)", form.Get("xpp"))
+	}
+	if _, err := (spysParser{}).Form(strings.NewReader("<html></html>")); err == nil {
+		t.Error("Form of a page without a session token succeeded")
+	}
+}
+
+func TestXORVars(t *testing.T) {
+	vars := xorVars("a=5012;b=a^3;c = 7 ^ b ^ a;\nd=unknown^1;e=4")
+	for name, want := range map[string]int{"a": 5012, "b": 5012 ^ 3, "c": 7 ^ 3, "e": 4} {
+		if got, ok := vars[name]; !ok || got != want {
+			t.Errorf("xorVars()[%q] = %d, %v; want %d", name, got, ok, want)
+		}
+	}
+	if _, ok := vars["d"]; ok {
+		t.Error("xorVars defined d from an undefined operand")
+	}
+}
+
+func TestGeonodeParser(t *testing.T) {
+	page := `{"data":[
+	{"ip":"51.158.68.133","port":"8811","protocols":["http","https"],"country":"fr","anonymityLevel":"elite","latency":48.6},
+	{"ip":"72.10.160.90","port":1289,"protocols":["socks5"],"country":"CA","responseTime":210},
+	{"ip":"999.1.1.1","port":"8080","protocols":["http"]},
+	{"ip":"","port":"","protocols":["http"]},
+	{"ip":"1.2.3.4","port":"0","protocols":["socks4"]},
+	{"ip":"5.6.7.8","port":"3128","protocols":["http"]}
+]}`
+	got := parseAll(t, geonodeParser{}, "https://proxylist.geonode.com/api/proxy-list?limit=500&page=1", page)
+	assertProxies(t, got, []string{
+		"http://51.158.68.133:8811",
+		"socks5://72.10.160.90:1289",
+		"http://5.6.7.8:3128",
+	})
+	want := []time.Duration{48600 * time.Microsecond, 210 * time.Millisecond, 0}
+	for i, proxy := range got {
+		if i < len(want) && proxy.Latency != want[i] {
+			t.Errorf("%s has latency %v, want %v", proxy, proxy.Latency, want[i])
+		}
+	}
+}
+
+func TestParserName(t *testing.T) {
+	for source, want := range map[string]string{
+		"https://free-proxy-list.net/":                                  "table",
+		"https://www.proxynova.com/proxy-server-list/":                  "table",
+		"https://proxylist.geonode.com/api/proxy-list?limit=500&page=1": "json",
+		"https://www.proxy-list.download/api/v1/get?type=http":          "text",
+		"https://spys.one/en/free-proxy-list/":                          "custom",
+		"https://unknown.example/list":                                  "table",
+	} {
+		if got := ParserName(source); got != want {
+			t.Errorf("ParserName(%s) = %q, want %q", source, got, want)
+		}
+	}
+}
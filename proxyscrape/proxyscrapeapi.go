@@ -0,0 +1,42 @@
+package proxyscrape
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// proxyscrapeAPIHost serves proxyscrape.com's proxy list API, which answers
+// with plain ip:port lines, unlike the site's own pages.
+const proxyscrapeAPIHost = "api.proxyscrape.com"
+
+// proxyscrapeKeyHeader carries the API key of a paid proxyscrape.com plan.
+const proxyscrapeKeyHeader = "X-Api-Key"
+
+// ProxyscrapeAPIOptions sets the parameters of requests to the
+// proxyscrape.com API. Zero fields keep what the source URL asks for.
+type ProxyscrapeAPIOptions struct {
+	Timeout time.Duration // leave out proxies the API saw answer slower than this
+	Country string        // ISO country code to list proxies from, or "all"
+	Key     string        // API key, sent in a header
+}
+
+// apply sets o's parameters on req if it is for the API.
+func (o ProxyscrapeAPIOptions) apply(req *http.Request) {
+	if req.URL.Host != proxyscrapeAPIHost {
+		return
+	}
+	if o.Timeout > 0 || o.Country != "" {
+		q := req.URL.Query()
+		if o.Timeout > 0 {
+			q.Set("timeout", strconv.FormatInt(o.Timeout.Milliseconds(), 10))
+		}
+		if o.Country != "" {
+			q.Set("country", o.Country)
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+	if o.Key != "" {
+		req.Header.Set(proxyscrapeKeyHeader, o.Key)
+	}
+}
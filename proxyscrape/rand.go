@@ -0,0 +1,74 @@
+package proxyscrape
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Rand is the source of every randomized decision the scraper makes, such
+// as User-Agent choice, retry jitter and source order, so a fixed seed
+// reproduces a run. It is safe for concurrent use, and a nil *Rand draws
+// from the global math/rand source.
+type Rand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// NewRand returns a Rand seeded with seed.
+func NewRand(seed uint64) *Rand {
+	return &Rand{r: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// Split returns a new Rand seeded from r, for a separate stream of
+// decisions that is reproducible from r's seed but doesn't repeat r's
+// draws, as a second NewRand with the same seed would.
+func (r *Rand) Split() *Rand {
+	if r == nil {
+		return NewRand(rand.Uint64())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Rand{r: rand.New(rand.NewPCG(r.r.Uint64(), r.r.Uint64()))}
+}
+
+// IntN returns a random int in [0, n).
+func (r *Rand) IntN(n int) int {
+	if r == nil {
+		return rand.IntN(n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.IntN(n)
+}
+
+// Duration returns a random duration in [0, d).
+func (r *Rand) Duration(d time.Duration) time.Duration {
+	if r == nil {
+		return rand.N(d)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.r.Int64N(int64(d)))
+}
+
+// Float64 returns a random float64 in [0, 1).
+func (r *Rand) Float64() float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+// Shuffle randomizes the order of n elements using swap.
+func (r *Rand) Shuffle(n int, swap func(i, j int)) {
+	if r == nil {
+		rand.Shuffle(n, swap)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.r.Shuffle(n, swap)
+}
@@ -0,0 +1,39 @@
+package proxyscrape
+
+import (
+	"slices"
+	"testing"
+)
+
+func draws(r *Rand) []int {
+	got := make([]int, 20)
+	for i := range got {
+		got[i] = r.IntN(1 << 30)
+	}
+	return got
+}
+
+func TestRandSplit(t *testing.T) {
+	split := func() [][]int {
+		r := NewRand(42)
+		a, b := r.Split(), r.Split()
+		return [][]int{draws(a), draws(b), draws(r)}
+	}
+	first, second := split(), split()
+	for i := range first {
+		if !slices.Equal(first[i], second[i]) {
+			t.Errorf("stream %d differs between runs with the same seed", i)
+		}
+	}
+	same := draws(NewRand(42))
+	for i, stream := range first {
+		if slices.Equal(stream, same) {
+			t.Errorf("stream %d repeats NewRand(42)'s draws", i)
+		}
+		for j := range i {
+			if slices.Equal(stream, first[j]) {
+				t.Errorf("streams %d and %d are the same", j, i)
+			}
+		}
+	}
+}
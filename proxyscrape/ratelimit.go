@@ -0,0 +1,46 @@
+package proxyscrape
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultHostDelay spaces out requests to the same host.
+const DefaultHostDelay = 2 * time.Second
+
+// HostLimiter spaces out requests per host with a token bucket each, so
+// paginated and retried fetches don't hammer a single site. A nil
+// *HostLimiter never waits.
+type HostLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	limiters map[string]*rate.Limiter
+}
+
+// NewHostLimiter allows one request per host every interval.
+func NewHostLimiter(interval time.Duration) *HostLimiter {
+	return &HostLimiter{
+		limit:    rate.Every(interval),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Wait blocks until a request to host is allowed or ctx is done.
+func (h *HostLimiter) Wait(ctx context.Context, host string) error {
+	if h == nil {
+		return nil
+	}
+	host = strings.ToLower(host)
+	h.mu.Lock()
+	l, ok := h.limiters[host]
+	if !ok {
+		l = rate.NewLimiter(h.limit, 1)
+		h.limiters[host] = l
+	}
+	h.mu.Unlock()
+	return l.Wait(ctx)
+}
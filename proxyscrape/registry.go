@@ -0,0 +1,114 @@
+package proxyscrape
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// A SourceFunc fetches every proxy one source currently publishes.
+type SourceFunc func(ctx context.Context) ([]Proxy, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]SourceFunc)
+)
+
+// RegisterSource adds a named source that the runner fetches alongside the
+// URL sources, so sources with bespoke formats can be compiled in from a
+// separate file without touching the scraper:
+//
+//	func init() {
+//		proxyscrape.RegisterSource("my-list", fetchMyList)
+//	}
+//
+// The built-in sources are registered the same way, as URLSources, under
+// their URLs.
+//
+// Proxies without a Source are labelled with name. RegisterSource panics if
+// name is empty, fetch is nil or the name is already taken.
+func RegisterSource(name string, fetch SourceFunc) {
+	if name == "" || fetch == nil {
+		panic("proxyscrape: RegisterSource needs a name and a fetch function")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("proxyscrape: source %q registered twice", name))
+	}
+	registry[name] = fetch
+}
+
+func init() {
+	for _, source := range DefaultSources {
+		RegisterSource(source, URLSource(source))
+	}
+}
+
+// scrapeOptionsKey is the context key FetchSource passes its options to
+// URL sources under.
+type scrapeOptionsKey struct{}
+
+// URLSource returns a source that scrapes the proxy site at source with
+// ScrapeSource, following its pages, under the options given to
+// FetchSource.
+func URLSource(source string) SourceFunc {
+	return func(ctx context.Context) ([]Proxy, error) {
+		opts, _ := ctx.Value(scrapeOptionsKey{}).(ScrapeOptions)
+		out := make(chan Proxy)
+		var proxies []Proxy
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for proxy := range out {
+				proxies = append(proxies, proxy)
+			}
+		}()
+		err := ScrapeSource(ctx, source, opts, out)
+		close(out)
+		<-done
+		return proxies, err
+	}
+}
+
+// RegisteredSources returns the names of all registered sources, sorted.
+func RegisteredSources() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// FetchSource runs the registered source name and sends what it returns to
+// out, labelled with name where the source left Source empty. URL sources
+// are scraped under opts; of the options, others only see
+// opts.MaxPerSource, which caps what is sent as it does for ScrapeSource.
+func FetchSource(ctx context.Context, name string, opts ScrapeOptions, out chan<- Proxy) error {
+	registryMu.RLock()
+	fetch, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no source registered as %q", name)
+	}
+
+	proxies, err := fetch(context.WithValue(ctx, scrapeOptionsKey{}, opts))
+	if opts.MaxPerSource > 0 && len(proxies) > opts.MaxPerSource {
+		proxies = proxies[:opts.MaxPerSource]
+	}
+	for _, proxy := range proxies {
+		if proxy.Source == "" {
+			proxy.Source = name
+		}
+		select {
+		case out <- proxy:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package proxyscrape
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// registered once, as sources are, so the test can run repeatedly
+func init() {
+	RegisterSource("test-fetch-source", func(context.Context) ([]Proxy, error) {
+		return []Proxy{
+			{IP: "1.1.1.1", Port: "80", Protocol: "http"},
+			{IP: "2.2.2.2", Port: "80", Protocol: "http", Source: "upstream-list"},
+			{IP: "3.3.3.3", Port: "80", Protocol: "http"},
+		}, nil
+	})
+}
+
+func TestFetchSource(t *testing.T) {
+	if !slices.Contains(RegisteredSources(), "test-fetch-source") {
+		t.Fatalf("RegisteredSources() = %q, missing test-fetch-source", RegisteredSources())
+	}
+
+	for _, tt := range []struct {
+		max  int
+		want []string
+	}{
+		{0, []string{"test-fetch-source", "upstream-list", "test-fetch-source"}},
+		{2, []string{"test-fetch-source", "upstream-list"}},
+		{5, []string{"test-fetch-source", "upstream-list", "test-fetch-source"}},
+	} {
+		out := make(chan Proxy, 10)
+		err := FetchSource(context.Background(), "test-fetch-source", ScrapeOptions{MaxPerSource: tt.max}, out)
+		close(out)
+		if err != nil {
+			t.Fatalf("FetchSource: %v", err)
+		}
+		var got []string
+		for proxy := range out {
+			got = append(got, proxy.Source)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("MaxPerSource %d: got sources %q, want %q", tt.max, got, tt.want)
+		}
+	}
+
+	if err := FetchSource(context.Background(), "no-such-source", ScrapeOptions{}, nil); err == nil {
+		t.Error("FetchSource of an unregistered name succeeded")
+	}
+}
+
+func TestFetchSourceBuiltin(t *testing.T) {
+	for _, source := range DefaultSources {
+		if !slices.Contains(RegisteredSources(), source) {
+			t.Errorf("built-in source %s is not registered", source)
+		}
+	}
+
+	opts := ScrapeOptions{Client: fixtureClient(t, sourcesHandler(t)), Retries: 1}
+	out := make(chan Proxy, 100)
+	err := FetchSource(context.Background(), "https://free-proxy-list.net/", opts, out)
+	close(out)
+	if err != nil {
+		t.Fatalf("FetchSource: %v", err)
+	}
+	var got []Proxy
+	for proxy := range out {
+		got = append(got, proxy)
+	}
+	assertProxies(t, got, []string{
+		"http://47.74.152.29:8888",
+		"http://160.86.242.23:8080",
+		"http://139.59.1.14:8080",
+	})
+	for _, proxy := range got {
+		if proxy.Source != "https://free-proxy-list.net/" {
+			t.Errorf("%s has Source %q, want the source URL", proxy, proxy.Source)
+		}
+	}
+}
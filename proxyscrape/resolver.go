@@ -0,0 +1,144 @@
+package proxyscrape
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NewResolver returns the resolver spec names: a DNS server as host or
+// host:port (port 53 by default), or the https URL of a DNS-over-HTTPS
+// endpoint such as https://1.1.1.1/dns-query. An empty spec returns nil,
+// which stands for the system resolver.
+func NewResolver(spec string) (*net.Resolver, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	if strings.Contains(spec, "://") {
+		u, err := url.Parse(spec)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS-over-HTTPS URL %q (want https://host/path)", spec)
+		}
+		client := &http.Client{Timeout: DefaultValidateTimeout}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return &dohConn{ctx: ctx, client: client, url: spec}, nil
+			},
+		}, nil
+	}
+
+	server := spec
+	if _, _, err := net.SplitHostPort(spec); err != nil {
+		server = net.JoinHostPort(strings.Trim(spec, "[]"), "53")
+	}
+	if host, port, err := net.SplitHostPort(server); err != nil || host == "" || !isValidPort(port) {
+		return nil, fmt.Errorf("invalid DNS server %q (want host, host:port or an https URL)", spec)
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}, nil
+}
+
+// dohConn carries a resolver's DNS messages to a DNS-over-HTTPS endpoint
+// (RFC 8484), one POST per query. Not being a net.PacketConn, the resolver
+// frames messages as over TCP, with a two-byte length prefix, which is
+// stripped from queries and added to answers.
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	deadline time.Time
+	queries  bytes.Buffer // framed queries not yet sent
+	answers  bytes.Buffer // framed answers not yet read
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	return c.queries.Write(b)
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.answers.Len() == 0 {
+		if err := c.exchange(); err != nil {
+			return 0, err
+		}
+	}
+	return c.answers.Read(b)
+}
+
+// exchange sends the first query written and queues its answer.
+func (c *dohConn) exchange() error {
+	if c.queries.Len() < 2 {
+		return io.EOF
+	}
+	n := int(binary.BigEndian.Uint16(c.queries.Bytes()))
+	if c.queries.Len() < 2+n {
+		return io.ErrUnexpectedEOF
+	}
+	c.queries.Next(2)
+	query := bytes.Clone(c.queries.Next(n))
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DNS-over-HTTPS server returned status %d", resp.StatusCode)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16-1))
+	if err != nil {
+		return err
+	}
+	c.answers.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+	c.answers.Write(answer)
+	return nil
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { c.deadline = t; return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
+
+// directClient returns a client for requests made without a proxy, such as
+// public IP and country lookups, resolving hosts with resolver, or the
+// system resolver if it is nil.
+func directClient(resolver *net.Resolver) *http.Client {
+	client := &http.Client{Timeout: DefaultValidateTimeout}
+	if resolver != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{Timeout: DefaultValidateTimeout, Resolver: resolver}).DialContext
+		client.Transport = transport
+	}
+	return client
+}
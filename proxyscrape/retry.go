@@ -0,0 +1,110 @@
+package proxyscrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfter caps how long a 429's Retry-After is honored; servers
+// asking for longer are given up on.
+const maxRetryAfter = 2 * time.Minute
+
+// doWithRetry sends req, retrying transient failures (network errors,
+// timeouts, 429 and 5xx) with jittered exponential backoff, or after the
+// delay a 429's Retry-After asks for. Permanent failures such as a 404 or
+// an unknown host are returned immediately, as are challenge pages, as an
+// error wrapping ErrChallenge. Every attempt first waits its turn with
+// limiter.
+func doWithRetry(ctx context.Context, client *http.Client, req *http.Request, limiter *HostLimiter, rnd *Rand, attempts int, backoff time.Duration) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if err := limiter.Wait(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+		attemptReq := req.Clone(ctx)
+		if req.GetBody != nil {
+			// each attempt needs the body afresh; the last one consumed it
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+		resp, err := client.Do(attemptReq)
+		if err == nil && isChallenge(resp) {
+			resp.Body.Close()
+			return nil, fmt.Errorf("status %d: %w", resp.StatusCode, ErrChallenge)
+		}
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if err != nil && !retryableError(ctx, err) {
+			return nil, err
+		}
+		if attempt >= attempts {
+			if err != nil {
+				return nil, err
+			}
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s returned status %d after %d attempts", req.URL, resp.StatusCode, attempt)
+		}
+
+		// Equal jitter: sleep somewhere in [backoff/2, backoff] so concurrent
+		// scrapers hitting the same host don't retry in lockstep. The +1
+		// keeps Duration's argument positive however small backoff is.
+		delay := backoff/2 + rnd.Duration(backoff/2+1)
+		if resp != nil {
+			resp.Body.Close()
+			if wait, ok := retryAfter(resp); ok {
+				if wait > maxRetryAfter {
+					return nil, fmt.Errorf("%s returned status %d with Retry-After %s", req.URL, resp.StatusCode, wait)
+				}
+				delay = wait
+			}
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+func retryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	return true
+}
+
+// retryAfter returns the delay a 429 response's Retry-After header asks
+// for, given either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return max(time.Until(when), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,194 @@
+package proxyscrape
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRobotsSize caps how much of a robots.txt is read, as RFC 9309 allows.
+const maxRobotsSize = 500 << 10
+
+// robotsRetryDelay is how long a host whose robots.txt couldn't be
+// fetched stays disallowed before the fetch is tried again.
+var robotsRetryDelay = time.Minute
+
+// Robots fetches each host's robots.txt once and answers whether a page
+// may be scraped. Only the rules for all user agents ("*") apply, since
+// requests go out with rotating browser User-Agents rather than a name of
+// our own. A robots.txt that couldn't be fetched disallows the host for
+// robotsRetryDelay rather than for good, so one failure doesn't block a
+// source in every later run. A nil *Robots allows everything.
+type Robots struct {
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+type robotsHost struct {
+	mu      sync.Mutex // held while fetching, so each host is fetched once
+	fetched bool
+	rules   []robotsRule
+	retryAt time.Time // when an unreachable robots.txt is fetched again
+}
+
+// robotsRule is one Allow or Disallow line; pattern is the path as written,
+// whose length decides which of several matching rules wins.
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// disallowAll stands in for the rules of a host whose robots.txt couldn't
+// be fetched, which RFC 9309 says to treat as disallowing everything.
+var disallowAll = []robotsRule{newRobotsRule(false, "/")}
+
+// NewRobots returns an empty robots.txt cache.
+func NewRobots() *Robots {
+	return &Robots{hosts: make(map[string]*robotsHost)}
+}
+
+// Allowed reports whether pageURL may be fetched, fetching its host's
+// robots.txt with client the first time the host is seen. The fetch goes
+// out like a page request, with a User-Agent from opts and after waiting
+// its turn with opts.HostLimiter. An error is returned, and nothing cached
+// for the host, only if ctx ends before the fetch does.
+func (r *Robots) Allowed(ctx context.Context, client *http.Client, pageURL string, opts ScrapeOptions) (bool, error) {
+	if r == nil {
+		return true, nil
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return true, nil
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	r.mu.Lock()
+	h, ok := r.hosts[origin]
+	if !ok {
+		h = &robotsHost{}
+		r.hosts[origin] = h
+	}
+	r.mu.Unlock()
+
+	h.mu.Lock()
+	if !h.fetched && !time.Now().Before(h.retryAt) {
+		if err := opts.HostLimiter.Wait(ctx, u.Host); err != nil {
+			h.mu.Unlock()
+			return false, err
+		}
+		rules, err := fetchRobots(ctx, client, origin+"/robots.txt", opts)
+		switch {
+		case ctx.Err() != nil:
+			h.mu.Unlock()
+			return false, ctx.Err()
+		case err != nil:
+			slog.Debug("robots.txt unreachable, treating host as disallowed", "url", origin+"/robots.txt", "error", err)
+			h.rules, h.retryAt = disallowAll, time.Now().Add(robotsRetryDelay)
+		default:
+			h.rules, h.fetched = rules, true
+		}
+	}
+	rules := h.rules
+	h.mu.Unlock()
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return robotsAllowed(rules, path), nil
+}
+
+// fetchRobots fetches and parses robotsURL. A missing robots.txt (any 4xx)
+// allows everything; an unreachable one (network error or 5xx) is returned
+// as an error.
+func fetchRobots(ctx context.Context, client *http.Client, robotsURL string, opts ScrapeOptions) ([]robotsRule, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", robotsURL, nil)
+	if err != nil {
+		return nil, nil
+	}
+	req.Header.Set("User-Agent", pickUserAgent(opts.Rand, opts.withDefaults().UserAgents))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return nil, nil
+	}
+	return parseRobots(io.LimitReader(resp.Body, maxRobotsSize)), nil
+}
+
+// parseRobots returns the Allow and Disallow rules of every group that
+// applies to all user agents.
+func parseRobots(r io.Reader) []robotsRule {
+	var rules []robotsRule
+	inAgents := false // in the User-agent lines that open a group
+	applies := false  // the current group names "*"
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				applies = false
+			}
+			inAgents = true
+			if value == "*" {
+				applies = true
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if applies && value != "" {
+				rules = append(rules, newRobotsRule(key == "allow", value))
+			}
+		}
+	}
+	return rules
+}
+
+// newRobotsRule compiles pattern, in which * matches any run of characters
+// and a trailing $ anchors the end of the path.
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	if strings.HasSuffix(expr, `\$`) {
+		expr = strings.TrimSuffix(expr, `\$`) + "$"
+	}
+	return robotsRule{allow: allow, pattern: pattern, re: regexp.MustCompile("^" + expr)}
+}
+
+// robotsAllowed applies the most specific rule matching path, that is the
+// one with the longest pattern, with Allow winning ties. A path no rule
+// matches is allowed.
+func robotsAllowed(rules []robotsRule, path string) bool {
+	allowed, longest := true, -1
+	for _, rule := range rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+			allowed, longest = rule.allow, n
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,135 @@
+package proxyscrape
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// robotsServer serves robots.txt with status and body as they are set at
+// the time of each request, counting the requests.
+type robotsServer struct {
+	status    atomic.Int64
+	body      atomic.Value // string
+	fetches   atomic.Int64
+	userAgent atomic.Value // string
+}
+
+func (s *robotsServer) start(t *testing.T) *httptest.Server {
+	t.Helper()
+	s.status.Store(http.StatusOK)
+	s.body.Store("")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			t.Errorf("unexpected request for %s", r.URL)
+			return
+		}
+		s.fetches.Add(1)
+		s.userAgent.Store(r.UserAgent())
+		w.WriteHeader(int(s.status.Load()))
+		io.WriteString(w, s.body.Load().(string))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	var rs robotsServer
+	srv := rs.start(t)
+	rs.body.Store("User-agent: *\nDisallow: /private\nAllow: /private/list$\n")
+	robots := NewRobots()
+	opts := ScrapeOptions{UserAgents: []string{"test-agent"}}
+	for path, want := range map[string]bool{
+		"/list":         true,
+		"/private":      false,
+		"/private/page": false,
+		"/private/list": true,
+	} {
+		got, err := robots.Allowed(context.Background(), srv.Client(), srv.URL+path, opts)
+		if err != nil || got != want {
+			t.Errorf("Allowed(%s) = %v, %v; want %v", path, got, err, want)
+		}
+	}
+	if n := rs.fetches.Load(); n != 1 {
+		t.Errorf("robots.txt fetched %d times, want once", n)
+	}
+	if ua := rs.userAgent.Load(); ua != "test-agent" {
+		t.Errorf("robots.txt fetched with User-Agent %q, want the scraper's", ua)
+	}
+}
+
+func TestRobotsRetriesFailedFetch(t *testing.T) {
+	defer func(d time.Duration) { robotsRetryDelay = d }(robotsRetryDelay)
+	var rs robotsServer
+	srv := rs.start(t)
+	robots := NewRobots()
+	allowed := func() bool {
+		t.Helper()
+		ok, err := robots.Allowed(context.Background(), srv.Client(), srv.URL+"/list", ScrapeOptions{})
+		if err != nil {
+			t.Fatalf("Allowed: %v", err)
+		}
+		return ok
+	}
+
+	rs.status.Store(http.StatusServiceUnavailable)
+	if allowed() {
+		t.Error("Allowed with robots.txt unreachable = true, want false")
+	}
+	rs.status.Store(http.StatusOK)
+	if allowed() {
+		t.Error("Allowed within robotsRetryDelay of a failed fetch = true, want false")
+	}
+	if n := rs.fetches.Load(); n != 1 {
+		t.Errorf("robots.txt fetched %d times within robotsRetryDelay, want once", n)
+	}
+
+	robotsRetryDelay = 0
+	robots = NewRobots()
+	rs.status.Store(http.StatusServiceUnavailable)
+	allowed()
+	rs.status.Store(http.StatusOK)
+	if !allowed() {
+		t.Error("Allowed once robots.txt is back = false, want true")
+	}
+	rs.status.Store(http.StatusServiceUnavailable)
+	if !allowed() {
+		t.Error("a fetched robots.txt was fetched again")
+	}
+	if n := rs.fetches.Load(); n != 3 {
+		t.Errorf("robots.txt fetched %d times, want 3", n)
+	}
+}
+
+func TestRobotsCancelled(t *testing.T) {
+	var rs robotsServer
+	srv := rs.start(t)
+	robots := NewRobots()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := robots.Allowed(ctx, srv.Client(), srv.URL+"/list", ScrapeOptions{}); err == nil {
+		t.Error("Allowed with a cancelled context returned no error")
+	}
+
+	// the host limiter's turn comes too late for the deadline
+	limiter := NewHostLimiter(time.Hour)
+	limiter.Wait(context.Background(), srv.Listener.Addr().String())
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := robots.Allowed(ctx, srv.Client(), srv.URL+"/list", ScrapeOptions{HostLimiter: limiter}); err == nil {
+		t.Error("Allowed past its deadline for the host limiter returned no error")
+	}
+	if n := rs.fetches.Load(); n != 0 {
+		t.Errorf("robots.txt fetched %d times without waiting for the host limiter", n)
+	}
+
+	ok, err := robots.Allowed(context.Background(), srv.Client(), srv.URL+"/list", ScrapeOptions{})
+	if err != nil || !ok {
+		t.Errorf("Allowed after cancelled calls = %v, %v; want true", ok, err)
+	}
+}
@@ -0,0 +1,265 @@
+package proxyscrape
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// rotateAttempts is how many upstreams a request is tried through before
+// the gateway gives up with 502.
+const rotateAttempts = 3
+
+// rotateMaxStreak is how many failures in a row mark an upstream dead.
+const rotateMaxStreak = 3
+
+// NewRotatingProxy returns an HTTP forward proxy that sends each incoming
+// request, CONNECT tunnels included, through a proxy from pool, favoring
+// upstreams that have worked before. Upstreams that keep failing are
+// removed from the pool, and a failed request is retried on another one
+// when it's safe to replay.
+func NewRotatingProxy(pool *ProxyPool, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		timeout = DefaultValidateTimeout
+	}
+	return &rotatingProxy{pool: pool, timeout: timeout}
+}
+
+type rotatingProxy struct {
+	pool    *ProxyPool
+	timeout time.Duration
+}
+
+func (rp *rotatingProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		rp.serveConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy; send absolute-form requests", http.StatusBadRequest)
+		return
+	}
+
+	// A request body can only be sent once, so only bodiless requests are
+	// retried on another upstream.
+	attempts := rotateAttempts
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		attempts = 1
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	removeHopHeaders(out.Header)
+
+	for range attempts {
+		upstream, ok := rp.pool.GetWeighted()
+		if !ok {
+			http.Error(w, "no live upstream proxies", http.StatusServiceUnavailable)
+			return
+		}
+		if rp.forward(w, out, upstream) {
+			return
+		}
+	}
+	http.Error(w, "all upstream proxies failed", http.StatusBadGateway)
+}
+
+// forward sends out through upstream, copying the response to w, and
+// reports whether the request is finished with; false means upstream
+// failed and the request may be tried on another.
+func (rp *rotatingProxy) forward(w http.ResponseWriter, out *http.Request, upstream Proxy) bool {
+	u, err := proxyURL(upstream.String())
+	if err != nil {
+		rp.pool.MarkDead(upstream.String())
+		return false
+	}
+	client, err := newProxyClient(u, rp.timeout, false, nil)
+	if err != nil {
+		rp.pool.MarkDead(upstream.String())
+		return false
+	}
+	// the transport is this request's alone; don't leave its conn open
+	defer client.CloseIdleConnections()
+
+	// RoundTrip doesn't apply client.Timeout, so bound the exchange here
+	ctx, cancel := context.WithTimeout(out.Context(), rp.timeout)
+	defer cancel()
+	resp, err := client.Transport.RoundTrip(out.WithContext(ctx))
+	if err != nil {
+		if out.Context().Err() != nil {
+			return true // the client went away
+		}
+		rp.failed(upstream, err)
+		return false
+	}
+	defer resp.Body.Close()
+	rp.pool.Report(upstream.String(), true)
+
+	removeHopHeaders(resp.Header)
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return true
+}
+
+// failed reports a failed request through upstream, marking it dead once
+// it has failed rotateMaxStreak times in a row.
+func (rp *rotatingProxy) failed(upstream Proxy, err error) {
+	rp.pool.Report(upstream.String(), false)
+	if rp.pool.failureStreak(upstream.String()) < rotateMaxStreak {
+		slog.Debug("upstream failed", "proxy", upstream.String(), "error", err)
+		return
+	}
+	slog.Debug("upstream failed, removing from pool", "proxy", upstream.String(), "error", err)
+	rp.pool.MarkDead(upstream.String())
+}
+
+func (rp *rotatingProxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var upstreamConn net.Conn
+	for range rotateAttempts {
+		upstream, ok := rp.pool.GetWeighted()
+		if !ok {
+			http.Error(w, "no live upstream proxies", http.StatusServiceUnavailable)
+			return
+		}
+		u, err := proxyURL(upstream.String())
+		if err == nil {
+			ctx, cancel := context.WithTimeout(r.Context(), rp.timeout)
+			upstreamConn, err = dialThrough(ctx, u, r.Host)
+			cancel()
+		}
+		if err == nil {
+			rp.pool.Report(upstream.String(), true)
+			break
+		}
+		rp.failed(upstream, err)
+	}
+	if upstreamConn == nil {
+		http.Error(w, "all upstream proxies failed", http.StatusBadGateway)
+		return
+	}
+	defer upstreamConn.Close()
+
+	clientConn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer clientConn.Close()
+	if _, err := io.WriteString(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstreamConn, buf) // buf drains anything already read, then the conn
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, upstreamConn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dialThrough opens a TCP tunnel to addr via the upstream proxy u, using
+// CONNECT for http proxies and the SOCKS handshake otherwise.
+func dialThrough(ctx context.Context, u *url.URL, addr string) (net.Conn, error) {
+	if u.Scheme != "http" {
+		dialer, err := xproxy.FromURL(u, xproxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.(xproxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("upstream CONNECT returned %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose first reads come from r, for bytes the
+// upstream sent right after its CONNECT response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// hopHeaders are connection-specific and must not be forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,74 @@
+package proxyscrape
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// upstreamProxy starts an http "proxy" answering every request with
+// handler, and returns it as a pool entry.
+func upstreamProxy(t *testing.T, handler http.HandlerFunc) Proxy {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	return Proxy{IP: host, Port: port, Protocol: "http"}
+}
+
+func TestRotatingProxyForwards(t *testing.T) {
+	upstream := upstreamProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.String() != "http://example.test/path" {
+			t.Errorf("upstream got request for %q", r.URL)
+		}
+		w.Header().Set("X-Upstream", "yes")
+		io.WriteString(w, "hello")
+	})
+	pool := &ProxyPool{}
+	pool.Add(upstream)
+	gateway := NewRotatingProxy(pool, time.Second)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/path", nil)
+	rec := httptest.NewRecorder()
+	gateway.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" || rec.Header().Get("X-Upstream") != "yes" {
+		t.Errorf("got %d %q (X-Upstream %q), want 200 \"hello\" from the upstream",
+			rec.Code, rec.Body.String(), rec.Header().Get("X-Upstream"))
+	}
+}
+
+func TestRotatingProxyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	upstream := upstreamProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	pool := &ProxyPool{}
+	pool.Add(upstream)
+	const timeout = 100 * time.Millisecond
+	gateway := NewRotatingProxy(pool, timeout)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	gateway.ServeHTTP(rec, req)
+	elapsed := time.Since(start)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("got status %d from a hung upstream, want %d", rec.Code, http.StatusBadGateway)
+	}
+	// one attempt per rotateAttempts, each cut off at the timeout
+	if max := rotateAttempts*timeout + time.Second; elapsed > max {
+		t.Errorf("gateway took %v to give up, want under %v", elapsed, max)
+	}
+	if pool.Len() != 0 {
+		t.Errorf("pool still holds the upstream after %d timeouts in a row", rotateAttempts)
+	}
+}
@@ -0,0 +1,374 @@
+package proxyscrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// DefaultSources is the built-in list of proxy sites.
+var DefaultSources = []string{
+	"https://free-proxy-list.net/",
+	"https://www.sslproxies.org/",
+	"https://www.us-proxy.org/",
+	"https://www.socks-proxy.net/",
+	"https://www.proxynova.com/proxy-server-list/",
+	"https://hidemy.name/en/proxy-list/",
+	"https://spys.one/en/free-proxy-list/",
+	"https://www.proxy-list.download/api/v1/get?type=http",
+	"https://www.proxy-list.download/api/v1/get?type=https",
+	"https://www.proxy-list.download/api/v1/get?type=socks4",
+	"https://www.proxy-list.download/api/v1/get?type=socks5",
+	"https://proxylist.geonode.com/api/proxy-list?limit=500&page=1",
+	"https://www.openproxy.space/list/",
+	"https://proxydb.net/",
+	"https://api.proxyscrape.com/v2/?request=getproxies&protocol=http&timeout=10000&country=all",
+	"https://api.proxyscrape.com/v2/?request=getproxies&protocol=socks4&timeout=10000&country=all",
+	"https://api.proxyscrape.com/v2/?request=getproxies&protocol=socks5&timeout=10000&country=all",
+}
+
+// Defaults applied to zero-valued ScrapeOptions fields.
+const (
+	DefaultScrapeTimeout = 10 * time.Second
+	DefaultScrapeRetries = 3
+	DefaultRetryBackoff  = time.Second
+	DefaultMaxPages      = 10
+)
+
+// ScrapeOptions controls how proxy sites are fetched.
+type ScrapeOptions struct {
+	Timeout      time.Duration // per-request timeout
+	Retries      int           // attempts per site, including the first
+	RetryBackoff time.Duration // delay before the first retry, doubled each time
+	MaxPages     int           // pages fetched from paginated sources
+	MaxPerSource int           // proxies sent on from each source; 0 means no limit
+	UserAgents   []string      // picked from at random per request
+	HostLimiter  *HostLimiter  // shared across sources; nil means no limit
+	Robots       *Robots       // if set, pages their host's robots.txt disallows are skipped
+	Proxy        *url.URL      // fetch sources through this http or socks proxy; nil means direct
+	Rand         *Rand         // drives User-Agent choice and retry jitter; nil uses the global source
+
+	// ChallengeProxy, if set, is the proxy a source is fetched through
+	// from the first page a challenge blocks on, such as one that solves
+	// them.
+	ChallengeProxy *url.URL
+
+	// CacheDir, if set, is where the raw body of every fetched page is
+	// saved. With FromCache, pages are read back from there instead of
+	// being fetched, so parsers can be re-run offline.
+	CacheDir  string
+	FromCache bool
+
+	// ProxyscrapeAPI overrides parameters of requests to the
+	// proxyscrape.com API.
+	ProxyscrapeAPI ProxyscrapeAPIOptions
+
+	// Client, if set, fetches every page in place of the client built from
+	// Timeout and Proxy. Its Transport can route requests for the real
+	// source hosts to a local server, so parsing can be exercised offline.
+	Client *http.Client
+}
+
+func (o ScrapeOptions) withDefaults() ScrapeOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultScrapeTimeout
+	}
+	if o.Retries <= 0 {
+		o.Retries = DefaultScrapeRetries
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = DefaultRetryBackoff
+	}
+	if o.MaxPages <= 0 {
+		o.MaxPages = DefaultMaxPages
+	}
+	if len(o.UserAgents) == 0 {
+		o.UserAgents = DefaultUserAgents
+	}
+	return o
+}
+
+// scrapeTransport is shared by every source's client. Compression is left
+// on, so it asks for gzip and hands parsers the decoded body.
+var scrapeTransport = &http.Transport{
+	MaxIdleConns:      10,
+	IdleConnTimeout:   30 * time.Second,
+	DisableKeepAlives: true,
+}
+
+// scrapeClient returns the client sources are fetched with: opts.Client if
+// set, otherwise one routed through opts.Proxy when that is set.
+func scrapeClient(opts ScrapeOptions) (*http.Client, error) {
+	if opts.Client != nil {
+		return opts.Client, nil
+	}
+	if opts.Proxy == nil {
+		return &http.Client{Timeout: opts.Timeout, Transport: scrapeTransport}, nil
+	}
+	transport := scrapeTransport.Clone()
+	if opts.Proxy.Scheme == "socks4" {
+		dialer, err := xproxy.FromURL(opts.Proxy, xproxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		transport.DialContext = dialer.(xproxy.ContextDialer).DialContext
+	} else {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+	return &http.Client{Timeout: opts.Timeout, Transport: transport}, nil
+}
+
+// Scrape fetches every source concurrently and returns all proxies found,
+// duplicates included. Per-source failures are returned joined together
+// alongside whatever the other sources produced.
+func Scrape(ctx context.Context, sources []string, opts ScrapeOptions) ([]Proxy, error) {
+	out := make(chan Proxy, 1000)
+	errs := make([]error, len(sources))
+
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = ScrapeSource(ctx, source, opts, out)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	var proxies []Proxy
+	for proxy := range out {
+		proxies = append(proxies, proxy)
+	}
+	return proxies, errors.Join(errs...)
+}
+
+// ScrapeSource fetches a single proxy site and sends every proxy it finds
+// to out. Paginated sources are followed, either through the parser's page
+// numbering or the page's "next" link, until a page yields nothing, links
+// back to a page already fetched, or opts.MaxPages is reached. With
+// opts.MaxPerSource set, only that many proxies are sent and no further
+// pages are fetched once it is reached.
+func ScrapeSource(ctx context.Context, source string, opts ScrapeOptions, out chan<- Proxy) error {
+	opts = opts.withDefaults()
+	client, err := scrapeClient(opts)
+	if err != nil {
+		return fmt.Errorf("upstream proxy: %w", err)
+	}
+
+	if opts.MaxPerSource > 0 {
+		// parsers send everything a page holds, so cap what's passed on
+		capped, done := make(chan Proxy), make(chan struct{})
+		go func(out chan<- Proxy) {
+			defer close(done)
+			sent := 0
+			for proxy := range capped {
+				if sent >= opts.MaxPerSource {
+					continue
+				}
+				select {
+				case out <- proxy:
+					sent++
+				case <-ctx.Done():
+				}
+			}
+		}(out)
+		defer func() {
+			close(capped)
+			<-done
+		}()
+		out = capped
+	}
+
+	parser := parserFor(source)
+	paged, isPaged := parser.(pagedParser)
+	pageURL := source
+	visited := map[string]bool{}
+	found := 0
+	for page := 1; ; page++ {
+		if !opts.FromCache {
+			allowed, err := opts.Robots.Allowed(ctx, client, pageURL, opts)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				slog.Info("skipping page disallowed by robots.txt", "source", source, "url", pageURL)
+				return nil
+			}
+		}
+		visited[pageURL] = true
+		n, next, err := scrapePage(ctx, client, source, pageURL, parser, opts, out)
+		if errors.Is(err, ErrChallenge) && opts.ChallengeProxy != nil && opts.Proxy != opts.ChallengeProxy {
+			slog.Info("page blocked by challenge, retrying through the challenge proxy", "source", source, "url", pageURL)
+			opts.Proxy, opts.Client = opts.ChallengeProxy, nil
+			if client, err = scrapeClient(opts); err != nil {
+				return fmt.Errorf("challenge proxy: %w", err)
+			}
+			n, next, err = scrapePage(ctx, client, source, pageURL, parser, opts, out)
+		}
+		if err != nil {
+			return err
+		}
+		found += n
+		if n == 0 || page >= opts.MaxPages || (opts.MaxPerSource > 0 && found >= opts.MaxPerSource) {
+			return nil
+		}
+		if isPaged {
+			next = paged.PageURL(source, page+1)
+		} else if next != "" {
+			next = resolveLink(pageURL, next)
+		}
+		if next == "" || visited[next] {
+			return nil
+		}
+		pageURL = next
+	}
+}
+
+// resolveLink resolves href against the page it was found on, returning ""
+// for links that don't lead to another http(s) page.
+func resolveLink(pageURL, href string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// scrapePage fetches one page of source and parses it, returning the
+// number of proxies found and the page's raw "next" link.
+func scrapePage(ctx context.Context, client *http.Client, source, pageURL string, parser Parser, opts ScrapeOptions, out chan<- Proxy) (int, string, error) {
+	var body io.ReadCloser
+	var err error
+	if form, ok := parser.(formParser); ok {
+		body, err = openFormPage(ctx, client, pageURL, form, opts)
+	} else {
+		body, err = openPage(ctx, client, pageURL, opts)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	defer body.Close()
+
+	n, next, err := parser.Parse(source, body, out)
+	if err != nil {
+		return n, "", fmt.Errorf("parsing %s: %w", pageURL, err)
+	}
+	return n, next, nil
+}
+
+// openPage returns the body of pageURL, fetched or, with opts.FromCache,
+// read from opts.CacheDir. Fetched pages are saved to opts.CacheDir when
+// it is set.
+func openPage(ctx context.Context, client *http.Client, pageURL string, opts ScrapeOptions) (io.ReadCloser, error) {
+	if opts.FromCache {
+		body, err := readCachedPage(opts.CacheDir, pageURL)
+		if err != nil {
+			return nil, fmt.Errorf("reading cached %s: %w", pageURL, err)
+		}
+		return body, nil
+	}
+
+	req, err := newPageRequest(ctx, http.MethodGet, pageURL, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	return fetchPage(ctx, client, req, opts)
+}
+
+// openFormPage returns the body of pageURL as served in answer to its
+// form, which parser fills in from the page first fetched with GET. Both
+// requests share a cookie jar and User-Agent, so the POST carries on the
+// session the GET started. Only the POST's response is cached, and with
+// opts.FromCache it is read back in place of both requests.
+func openFormPage(ctx context.Context, client *http.Client, pageURL string, parser formParser, opts ScrapeOptions) (io.ReadCloser, error) {
+	if opts.FromCache {
+		return openPage(ctx, client, pageURL, opts)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	session := *client
+	session.Jar = jar
+	opts.UserAgents = []string{pickUserAgent(opts.Rand, opts.UserAgents)}
+
+	getOpts := opts
+	getOpts.CacheDir = ""
+	page, err := openPage(ctx, &session, pageURL, getOpts)
+	if err != nil {
+		return nil, err
+	}
+	form, err := parser.Form(page)
+	page.Close()
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", pageURL, err)
+	}
+
+	req, err := newPageRequest(ctx, http.MethodPost, pageURL, strings.NewReader(form.Encode()), opts)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", pageURL)
+	return fetchPage(ctx, &session, req, opts)
+}
+
+// newPageRequest returns a request for pageURL with browser-like headers,
+// and the parameters opts gives for the proxyscrape.com API if it's that.
+func newPageRequest(ctx context.Context, method, pageURL string, body io.Reader, opts ScrapeOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, pageURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", pickUserAgent(opts.Rand, opts.UserAgents))
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("Accept-Language", pickAcceptLanguage(opts.Rand))
+	opts.ProxyscrapeAPI.apply(req)
+	return req, nil
+}
+
+// fetchPage sends req, with retries, and returns the body of a successful
+// response, saved to opts.CacheDir when that is set.
+func fetchPage(ctx context.Context, client *http.Client, req *http.Request, opts ScrapeOptions) (io.ReadCloser, error) {
+	pageURL := req.URL.String()
+	resp, err := doWithRetry(ctx, client, req, opts.HostLimiter, opts.Rand, opts.Retries, opts.RetryBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", pageURL, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// an error page parsed as a proxy list yields nothing or garbage
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: status %d", pageURL, resp.StatusCode)
+	}
+	if opts.CacheDir == "" {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	body, err := cachePage(opts.CacheDir, pageURL, resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("caching %s: %w", pageURL, err)
+	}
+	return body, nil
+}
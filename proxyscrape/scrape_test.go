@@ -0,0 +1,152 @@
+package proxyscrape
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// redirectTransport sends every request to a test server, whatever host
+// its URL names; the server sees the original host in r.Host.
+type redirectTransport struct {
+	addr string
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = t.addr
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fixtureClient returns a client whose requests are all answered by
+// handler, standing in for the real sources.
+func fixtureClient(t *testing.T, handler http.HandlerFunc) *http.Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &http.Client{Transport: redirectTransport{addr: srv.Listener.Addr().String()}}
+}
+
+const geonodePage1 = `{"data":[
+	{"ip":"51.158.68.133","port":"8811","protocols":["http","https"],"country":"fr","anonymityLevel":"elite"},
+	{"ip":"72.10.160.90","port":1289,"protocols":["socks5"],"country":"CA","anonymityLevel":"anonymous"}
+],"total":2,"page":1,"limit":500}`
+
+const proxyListDownloadBody = "103.152.112.162:80\r\n" +
+	"not a proxy\r\n" +
+	"  45.77.136.215:1080  \r\n" +
+	"1.2.3.4:99999\r\n" +
+	"\r\n"
+
+func sourcesHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host {
+		case "free-proxy-list.net":
+			io.WriteString(w, freeProxyListPage)
+		case "proxylist.geonode.com":
+			w.Header().Set("Content-Type", "application/json")
+			if page := r.URL.Query().Get("page"); page == "" || page == "1" {
+				io.WriteString(w, geonodePage1)
+			} else {
+				io.WriteString(w, `{"data":[],"total":2}`)
+			}
+		case "www.proxy-list.download":
+			io.WriteString(w, proxyListDownloadBody)
+		case "down.example":
+			http.Error(w, "maintenance", http.StatusInternalServerError)
+		case "challenged.example":
+			w.Header().Set("Cf-Mitigated", "challenge")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "<title>Just a moment...</title>")
+		default:
+			t.Errorf("unexpected request for %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestScrapeSource(t *testing.T) {
+	opts := ScrapeOptions{Client: fixtureClient(t, sourcesHandler(t)), Retries: 1}
+	tests := []struct {
+		source string
+		want   []Proxy
+	}{
+		{
+			source: "https://free-proxy-list.net/",
+			want: []Proxy{
+				{IP: "47.74.152.29", Port: "8888", Protocol: "http", Country: "SG", Anonymity: Anonymous},
+				{IP: "160.86.242.23", Port: "8080", Protocol: "http", Country: "JP", Anonymity: Elite},
+				{IP: "139.59.1.14", Port: "8080", Protocol: "http"},
+			},
+		},
+		{
+			source: "https://proxylist.geonode.com/api/proxy-list?limit=500",
+			want: []Proxy{
+				{IP: "51.158.68.133", Port: "8811", Protocol: "http", Country: "FR", Anonymity: Elite},
+				{IP: "72.10.160.90", Port: "1289", Protocol: "socks5", Country: "CA", Anonymity: Anonymous},
+			},
+		},
+		{
+			source: "https://www.proxy-list.download/api/v1/get?type=socks5",
+			want: []Proxy{
+				{IP: "103.152.112.162", Port: "80", Protocol: "socks5"},
+				{IP: "45.77.136.215", Port: "1080", Protocol: "socks5"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		out := make(chan Proxy, 100)
+		err := ScrapeSource(context.Background(), tt.source, opts, out)
+		close(out)
+		if err != nil {
+			t.Errorf("ScrapeSource(%s): %v", tt.source, err)
+			continue
+		}
+		var got []Proxy
+		for proxy := range out {
+			got = append(got, proxy)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ScrapeSource(%s) sent %d proxies, want %d: %+v", tt.source, len(got), len(tt.want), got)
+			continue
+		}
+		for i, want := range tt.want {
+			want.Source = tt.source
+			if got[i] != want {
+				t.Errorf("ScrapeSource(%s) proxy %d = %+v, want %+v", tt.source, i, got[i], want)
+			}
+		}
+	}
+}
+
+func TestScrapeSourceMaxPerSource(t *testing.T) {
+	opts := ScrapeOptions{Client: fixtureClient(t, sourcesHandler(t)), Retries: 1, MaxPerSource: 2}
+	out := make(chan Proxy, 100)
+	err := ScrapeSource(context.Background(), "https://free-proxy-list.net/", opts, out)
+	close(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 {
+		t.Errorf("ScrapeSource with MaxPerSource 2 sent %d proxies", len(out))
+	}
+}
+
+func TestScrapeSourceErrors(t *testing.T) {
+	opts := ScrapeOptions{Client: fixtureClient(t, sourcesHandler(t)), Retries: 1}
+	out := make(chan Proxy, 100)
+	if err := ScrapeSource(context.Background(), "https://down.example/", opts, out); err == nil {
+		t.Error("ScrapeSource of a source answering 500 succeeded")
+	}
+	err := ScrapeSource(context.Background(), "https://challenged.example/", opts, out)
+	if !errors.Is(err, ErrChallenge) {
+		t.Errorf("ScrapeSource of a challenged source = %v, want ErrChallenge", err)
+	}
+	if len(out) != 0 {
+		t.Errorf("failed sources sent %d proxies", len(out))
+	}
+}
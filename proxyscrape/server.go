@@ -0,0 +1,77 @@
+package proxyscrape
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// NewServer returns a handler serving the pool over HTTP:
+//
+//	GET /proxies         every live proxy
+//	GET /proxies/random  one live proxy chosen at random, with pool.Rand
+//
+// Both accept comma-separated ?protocol= and ?country= filters, and answer
+// with JSON when the Accept header asks for it and plain text otherwise.
+func NewServer(pool *ProxyPool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /proxies", func(w http.ResponseWriter, r *http.Request) {
+		proxies := pool.Filter(queryFilter(r))
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			writeJSON(w, proxies)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeTXT(w, proxies)
+	})
+	mux.HandleFunc("GET /proxies/random", func(w http.ResponseWriter, r *http.Request) {
+		proxies := pool.Filter(queryFilter(r))
+		if len(proxies) == 0 {
+			http.Error(w, "no matching proxies", http.StatusNotFound)
+			return
+		}
+		proxy := proxies[pool.Rand.IntN(len(proxies))]
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(newJSONProxy(proxy))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, proxy.String()+"\n")
+	})
+	return mux
+}
+
+// queryFilter builds a predicate from the request's protocol and country
+// query parameters. Absent parameters match everything.
+func queryFilter(r *http.Request) func(Proxy) bool {
+	protocols := querySet(r, "protocol", strings.ToLower)
+	countries := querySet(r, "country", strings.ToUpper)
+	return func(p Proxy) bool {
+		if len(protocols) > 0 && !protocols[p.Protocol] {
+			return false
+		}
+		if len(countries) > 0 && !countries[p.Country] {
+			return false
+		}
+		return true
+	}
+}
+
+func querySet(r *http.Request, key string, normalize func(string) string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range r.URL.Query()[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				set[normalize(item)] = true
+			}
+		}
+	}
+	return set
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
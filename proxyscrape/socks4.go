@@ -0,0 +1,107 @@
+package proxyscrape
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// net/http and x/net/proxy only speak SOCKS5, so SOCKS4 proxies are dialed
+// through this minimal CONNECT-only client registered with x/net/proxy.
+func init() {
+	proxy.RegisterDialerType("socks4", func(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+		return &socks4Dialer{addr: u.Host, userID: u.User.Username(), forward: forward}, nil
+	})
+}
+
+// errSOCKS4 is wrapped by the errors a SOCKS4 proxy's reply gives rise to,
+// so they can be told from errors reaching the proxy.
+var errSOCKS4 = errors.New("socks4")
+
+type socks4Dialer struct {
+	addr    string
+	userID  string
+	forward proxy.Dialer
+}
+
+func (d *socks4Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *socks4Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" {
+		return nil, fmt.Errorf("socks4: unsupported network %q", network)
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("socks4: invalid port %q", portStr)
+	}
+
+	// SOCKS4 carries only an IPv4 destination, so resolve locally, with
+	// the resolver the proxy itself is dialed with.
+	resolver := net.DefaultResolver
+	if nd, ok := d.forward.(*net.Dialer); ok && nd.Resolver != nil {
+		resolver = nd.Resolver
+	}
+	ips, err := resolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("socks4: no IPv4 address for %s", host)
+	}
+
+	var conn net.Conn
+	if cd, ok := d.forward.(proxy.ContextDialer); ok {
+		conn, err = cd.DialContext(ctx, "tcp", d.addr)
+	} else {
+		conn, err = d.forward.Dial("tcp", d.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := make([]byte, 0, 9+len(d.userID))
+	req = append(req, 4, 1)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	req = append(req, ips[0].To4()...)
+	req = append(req, d.userID...)
+	req = append(req, 0)
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var resp [8]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp[0] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("%w: malformed reply", errSOCKS4)
+	}
+	if resp[1] != 90 {
+		conn.Close()
+		return nil, fmt.Errorf("%w: request rejected (code %d)", errSOCKS4, resp[1])
+	}
+	return conn, nil
+}
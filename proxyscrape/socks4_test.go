@@ -0,0 +1,98 @@
+package proxyscrape
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// pipeDialer hands out the client end of a net.Pipe for every dial and
+// runs serve on the other end, standing in for the network between a
+// socks4Dialer and its proxy.
+type pipeDialer struct {
+	serve func(net.Conn)
+	addr  string // the address last dialed
+}
+
+func (d *pipeDialer) Dial(network, addr string) (net.Conn, error) {
+	d.addr = addr
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		d.serve(server)
+	}()
+	return client, nil
+}
+
+func TestSocks4Dial(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   []byte
+		wantErr string
+	}{
+		{name: "granted", reply: []byte{0, 90, 0, 0, 0, 0, 0, 0}},
+		{name: "rejected", reply: []byte{0, 91, 0, 0, 0, 0, 0, 0}, wantErr: "request rejected (code 91)"},
+		{name: "malformed", reply: []byte{4, 90, 0, 0, 0, 0, 0, 0}, wantErr: "malformed reply"},
+		{name: "short reply", reply: []byte{0, 90}, wantErr: "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// CONNECT (1) to 127.0.0.1:8080 as user "alice", NUL-terminated.
+			wantReq := []byte{4, 1, 0x1f, 0x90, 127, 0, 0, 1, 'a', 'l', 'i', 'c', 'e', 0}
+			got := make(chan []byte, 1)
+			forward := &pipeDialer{serve: func(c net.Conn) {
+				req := make([]byte, len(wantReq))
+				io.ReadFull(c, req)
+				got <- req
+				c.Write(tt.reply)
+				if tt.wantErr == "" {
+					c.Write([]byte("payload"))
+				}
+			}}
+			d := &socks4Dialer{addr: "192.0.2.1:1080", userID: "alice", forward: forward}
+
+			conn, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:8080")
+			if req := <-got; !bytes.Equal(req, wantReq) {
+				t.Errorf("request = %v, want %v", req, wantReq)
+			}
+			if forward.addr != "192.0.2.1:1080" {
+				t.Errorf("dialed %q, want the proxy at 192.0.2.1:1080", forward.addr)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DialContext error = %v, want one containing %q", err, tt.wantErr)
+				}
+				if got := classifyError(err); got != FailureProxy && got != FailureReset {
+					t.Errorf("classifyError(%v) = %v, want a proxy failure", err, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DialContext: %v", err)
+			}
+			defer conn.Close()
+			payload, _ := io.ReadAll(conn)
+			if string(payload) != "payload" {
+				t.Errorf("read %q through the tunnel, want %q", payload, "payload")
+			}
+		})
+	}
+}
+
+func TestSocks4DialRejectsBadTargets(t *testing.T) {
+	d := &socks4Dialer{addr: "192.0.2.1:1080", forward: &pipeDialer{serve: func(net.Conn) {}}}
+	for _, tt := range []struct{ network, addr string }{
+		{"udp", "127.0.0.1:53"},
+		{"tcp6", "[::1]:80"},
+		{"tcp", "127.0.0.1"},
+		{"tcp", "127.0.0.1:0"},
+		{"tcp", "127.0.0.1:65536"},
+	} {
+		if _, err := d.DialContext(context.Background(), tt.network, tt.addr); err == nil {
+			t.Errorf("DialContext(%q, %q) succeeded, want an error", tt.network, tt.addr)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package proxyscrape
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go driver, no cgo
+)
+
+// Store keeps proxy history across runs in a SQLite database.
+type Store struct {
+	db *sql.DB
+}
+
+// StoredProxy is a proxy's accumulated history.
+type StoredProxy struct {
+	Proxy        string
+	FirstSeen    time.Time
+	LastSeen     time.Time // last time the proxy passed a check
+	LastLatency  time.Duration
+	TotalChecks  int
+	SuccessCount int
+}
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS proxies (
+	proxy           TEXT PRIMARY KEY,
+	first_seen      INTEGER NOT NULL,
+	last_seen       INTEGER NOT NULL,
+	last_latency_ms INTEGER NOT NULL DEFAULT 0,
+	total_checks    INTEGER NOT NULL DEFAULT 0,
+	success_count   INTEGER NOT NULL DEFAULT 0
+)`
+
+// OpenStore opens or creates the database at path.
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Record folds one run's check results into the history. Live proxies
+// (HTTPOK) are upserted; dead ones only count against proxies that have
+// been live before, so the table doesn't fill up with junk.
+func (s *Store) Record(ctx context.Context, checked []Proxy) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	for _, p := range checked {
+		if p.HTTPOK {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO proxies (proxy, first_seen, last_seen, last_latency_ms, total_checks, success_count)
+				VALUES (?, ?, ?, ?, 1, 1)
+				ON CONFLICT (proxy) DO UPDATE SET
+					last_seen = excluded.last_seen,
+					last_latency_ms = excluded.last_latency_ms,
+					total_checks = total_checks + 1,
+					success_count = success_count + 1`,
+				p.String(), now, now, p.Latency.Milliseconds())
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE proxies SET total_checks = total_checks + 1 WHERE proxy = ?`,
+				p.String())
+		}
+		if err != nil {
+			return fmt.Errorf("recording %s: %w", p, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Top returns the n proxies with the best success rate, breaking ties by
+// the number of successes and then by latency.
+func (s *Store) Top(ctx context.Context, n int) ([]StoredProxy, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT proxy, first_seen, last_seen, last_latency_ms, total_checks, success_count
+		FROM proxies
+		ORDER BY CAST(success_count AS REAL) / total_checks DESC, success_count DESC, last_latency_ms ASC
+		LIMIT ?`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []StoredProxy
+	for rows.Next() {
+		var sp StoredProxy
+		var firstSeen, lastSeen, latencyMs int64
+		if err := rows.Scan(&sp.Proxy, &firstSeen, &lastSeen, &latencyMs, &sp.TotalChecks, &sp.SuccessCount); err != nil {
+			return nil, err
+		}
+		sp.FirstSeen = time.Unix(firstSeen, 0)
+		sp.LastSeen = time.Unix(lastSeen, 0)
+		sp.LastLatency = time.Duration(latencyMs) * time.Millisecond
+		top = append(top, sp)
+	}
+	return top, rows.Err()
+}
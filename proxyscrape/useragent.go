@@ -0,0 +1,31 @@
+package proxyscrape
+
+// DefaultUserAgents is a pool of current desktop browser User-Agents that
+// scrape requests pick from, so every request doesn't share one signature.
+var DefaultUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:131.0) Gecko/20100101 Firefox/131.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.0 Safari/605.1.15",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.7; rv:131.0) Gecko/20100101 Firefox/131.0",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0",
+}
+
+// acceptLanguages pairs with the User-Agent pool; a real browser always
+// sends one and its absence is an easy bot tell.
+var acceptLanguages = []string{
+	"en-US,en;q=0.9",
+	"en-GB,en;q=0.9",
+	"en-US,en;q=0.8,de;q=0.6",
+	"en-US,en;q=0.9,fr;q=0.7",
+}
+
+func pickUserAgent(rnd *Rand, agents []string) string {
+	return agents[rnd.IntN(len(agents))]
+}
+
+func pickAcceptLanguage(rnd *Rand) string {
+	return acceptLanguages[rnd.IntN(len(acceptLanguages))]
+}
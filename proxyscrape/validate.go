@@ -0,0 +1,586 @@
+package proxyscrape
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// isValidIP reports whether ip is a literal IPv4 or IPv6 address.
+func isValidIP(ip string) bool {
+	return net.ParseIP(ip) != nil
+}
+
+// isValidPort reports whether port is a decimal TCP port number.
+func isValidPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+// Defaults applied to zero-valued ValidateOptions fields.
+const (
+	DefaultWorkers         = 20
+	DefaultValidateTimeout = 7 * time.Second
+	DefaultCheckURL        = "http://api.ipify.org"
+	DefaultJudge           = "http://httpbin.org/get"
+	DefaultHTTPSCheckURL   = "https://api.ipify.org"
+	DefaultCheckAttempts   = 2
+)
+
+// checkRetryBackoff is the pause before re-testing a proxy that failed its
+// check, doubled after each further failure.
+const checkRetryBackoff = 500 * time.Millisecond
+
+// ValidateOptions controls what ValidateProxy considers a working proxy and
+// which working proxies Validate keeps.
+type ValidateOptions struct {
+	Workers      int // concurrent validators used by Validate and ValidateStream
+	Timeout      time.Duration
+	CheckURL     string // fetched through the proxy
+	ExpectStatus int    // required response status
+	Expect       string // if set, the response body must contain it
+
+	// CheckURLs, if set, are interchangeable alternatives to CheckURL.
+	// Checks rotate through CheckURL and these to spread load, and a failed
+	// check is retried against the next one, so an endpoint that is down
+	// or blocks the proxy doesn't get it declared dead.
+	CheckURLs []string
+
+	// ProbeMethod is the method used for the check request, GET by
+	// default. HEAD is lighter but returns no body, so GET is used anyway
+	// when Expect or MinAnonymity needs one.
+	ProbeMethod string
+
+	// Attempts is how many times a failing check is tried, with a short
+	// backoff in between, before the proxy is declared dead. Confirmations
+	// is how many further checks a proxy must pass after the first before
+	// it counts as alive, which weeds out proxies that answer once and
+	// then vanish.
+	Attempts      int
+	Confirmations int
+
+	// Warmup sends each proxy the check request once before checking it
+	// and ignores the outcome, for proxies that fail their first request
+	// while they set up upstream state. Proxy.ColdFail records whether the
+	// warm-up failed. It also opens the connection the check then reuses,
+	// so latency excludes connecting to the proxy.
+	Warmup bool
+
+	Judge  string // header-echo endpoint used for anonymity detection
+	RealIP string // our own public IP; see LookupPublicIP
+
+	// CheckHTTPS additionally fetches HTTPSCheckURL through each proxy,
+	// which makes http proxies tunnel TLS with CONNECT, and records the
+	// outcome in Proxy.HTTPSOK. RequireHTTPS implies it and drops proxies
+	// that can't tunnel.
+	CheckHTTPS    bool
+	RequireHTTPS  bool
+	HTTPSCheckURL string
+
+	// TargetURL, if set, is the site the proxies are actually wanted for,
+	// which may block proxies the check URL doesn't. It is fetched through
+	// every live proxy, retried like the check itself, and proxies that
+	// don't get TargetStatus (200 by default) back are dropped. The outcome
+	// is recorded in Proxy.TargetOK.
+	TargetURL    string
+	TargetStatus int
+
+	// Resolver, if set, resolves the hostnames validation looks up itself:
+	// those of proxies, the targets of socks4 proxies and the hosts public
+	// IP and country lookups are made to. Through http and socks5 proxies
+	// the proxy resolves the check URL's host. See NewResolver.
+	Resolver *net.Resolver
+
+	// InsecureSkipVerify disables certificate verification for https check
+	// and judge URLs. It is unsafe, since anyone on the path, the proxy
+	// included, can then impersonate the endpoint; only use it for
+	// endpoints with self-signed certificates.
+	InsecureSkipVerify bool
+
+	// ProxyTransport, if set, builds the RoundTripper for requests through
+	// a proxy in place of a real connection to it, so the outcome of a
+	// check can be simulated without a network.
+	ProxyTransport func(proxy *url.URL) (http.RoundTripper, error)
+
+	// MinAnonymity enables anonymity detection and drops proxies below it.
+	// DropTransparent enables it too, dropping only transparent proxies,
+	// which hand our real IP to the sites they're used for.
+	MinAnonymity    Anonymity
+	DropTransparent bool
+	// Countries, if non-empty, keeps only proxies in these ISO codes.
+	Countries map[string]bool
+
+	// MaxWorkers, if set, makes the number of concurrent validators adapt
+	// to the network instead of staying at Workers. It starts at
+	// MinWorkers (a quarter of MaxWorkers by default) and is halved when
+	// most recent checks time out, as they do when the link is saturated,
+	// and raised while few do. OnConcurrency, if set, is called with the
+	// starting level and each new one.
+	MinWorkers    int
+	MaxWorkers    int
+	OnConcurrency func(workers int)
+
+	// client, if set, is the client every check of one proxy shares, so
+	// they can reuse its connection; see checkProxy.
+	client *http.Client
+
+	// OnChecked, if set, is called from the worker goroutines with every
+	// proxy that was checked, live or dead, before any filter is applied
+	// to the output. Proxy.HTTPOK tells the two apart.
+	OnChecked func(Proxy)
+}
+
+func (o ValidateOptions) withDefaults() ValidateOptions {
+	if o.Workers <= 0 {
+		o.Workers = DefaultWorkers
+	}
+	if o.MaxWorkers > 0 {
+		if o.MinWorkers <= 0 {
+			o.MinWorkers = max(1, o.MaxWorkers/4)
+		}
+		o.MinWorkers = min(o.MinWorkers, o.MaxWorkers)
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultValidateTimeout
+	}
+	if o.CheckURL == "" {
+		o.CheckURL = DefaultCheckURL
+	}
+	if o.Attempts <= 0 {
+		o.Attempts = DefaultCheckAttempts
+	}
+	if o.ProbeMethod == "" || (o.ProbeMethod == http.MethodHead && (o.Expect != "" || o.MinAnonymity != AnonymityUnknown)) {
+		o.ProbeMethod = http.MethodGet
+	}
+	if o.ExpectStatus == 0 {
+		o.ExpectStatus = http.StatusOK
+	}
+	if o.Judge == "" {
+		o.Judge = DefaultJudge
+	}
+	if o.HTTPSCheckURL == "" {
+		o.HTTPSCheckURL = DefaultHTTPSCheckURL
+	}
+	if o.TargetStatus == 0 {
+		o.TargetStatus = http.StatusOK
+	}
+	return o
+}
+
+// detectsAnonymity reports whether checks include anonymity detection,
+// which needs RealIP.
+func (o ValidateOptions) detectsAnonymity() bool {
+	return o.MinAnonymity != AnonymityUnknown || o.DropTransparent
+}
+
+// Validate checks every proxy and returns the ones that pass, with latency
+// and any detected metadata filled in. If ctx is cancelled the proxies
+// validated so far are returned along with ctx.Err().
+func Validate(ctx context.Context, proxies []Proxy, opts ValidateOptions) ([]Proxy, error) {
+	if opts.detectsAnonymity() && opts.RealIP == "" {
+		realIP, err := LookupPublicIPUsing(ctx, opts.Resolver)
+		if err != nil {
+			return nil, fmt.Errorf("looking up public IP: %w", err)
+		}
+		opts.RealIP = realIP
+	}
+
+	in := make(chan Proxy)
+	go func() {
+		defer close(in)
+		for _, proxy := range proxies {
+			select {
+			case in <- proxy:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var valid []Proxy
+	for proxy := range ValidateStream(ctx, in, opts) {
+		valid = append(valid, proxy)
+	}
+	return valid, ctx.Err()
+}
+
+// ValidateStream validates proxies from in with opts.Workers workers, or
+// between opts.MinWorkers and opts.MaxWorkers, and sends the ones that pass
+// to the returned channel, which is closed once in is closed and drained.
+// After ctx is cancelled remaining input is drained without being checked.
+func ValidateStream(ctx context.Context, in <-chan Proxy, opts ValidateOptions) <-chan Proxy {
+	opts = opts.withDefaults()
+	out := make(chan Proxy, 1000)
+	geo := newGeoCache(opts.Resolver)
+
+	workers := opts.Workers
+	var gate *workerGate
+	if opts.MaxWorkers > 0 {
+		workers = opts.MaxWorkers
+		gate = newWorkerGate(opts.MinWorkers, opts.MaxWorkers, opts.OnConcurrency)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for proxy := range in {
+				if ctx.Err() != nil {
+					continue // drain so upstream goroutines can exit
+				}
+				if gate != nil {
+					gate.acquire()
+				}
+				keep := checkProxy(ctx, &proxy, opts, geo)
+				if gate != nil {
+					gate.release(proxy.Failure == FailureTimeout)
+				}
+				if opts.OnChecked != nil && ctx.Err() == nil {
+					opts.OnChecked(proxy)
+				}
+				if keep {
+					out <- proxy
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// checkProxy runs every enabled check against proxy, recording what it
+// learns on it, and reports whether the proxy should be kept.
+func checkProxy(ctx context.Context, proxy *Proxy, opts ValidateOptions, geo *geoCache) bool {
+	// One client for all of the proxy's checks, so retries, confirmations
+	// and the HTTPS, target and anonymity checks reuse its connection
+	// rather than each opening their own.
+	if u, err := proxyURL(proxy.String()); err == nil {
+		if client, release, err := opts.proxyClient(u); err == nil {
+			defer release()
+			opts.client = client
+		}
+	}
+	if opts.Warmup {
+		warm, _, _ := validateProxy(ctx, proxy.String(), opts)
+		proxy.ColdFail = !warm
+	}
+	ok, latency, reason := checkAlive(ctx, proxy.String(), opts)
+	if !ok {
+		proxy.Failure = reason
+		return false
+	}
+	proxy.Latency = latency
+	proxy.HTTPOK = true
+	if opts.CheckHTTPS || opts.RequireHTTPS {
+		proxy.HTTPSOK = checkHTTPS(ctx, proxy.String(), opts)
+		if opts.RequireHTTPS && !proxy.HTTPSOK {
+			return false
+		}
+	}
+	if opts.TargetURL != "" {
+		proxy.TargetOK = checkTarget(ctx, proxy.String(), opts)
+		if !proxy.TargetOK {
+			return false
+		}
+	}
+	if opts.detectsAnonymity() {
+		level, err := DetectAnonymity(ctx, proxy.String(), opts)
+		if err != nil || level < opts.MinAnonymity {
+			return false
+		}
+		if opts.DropTransparent && level == Transparent {
+			slog.Debug("transparent", "proxy", proxy.String())
+			return false
+		}
+		proxy.Anonymity = level
+	}
+	if len(opts.Countries) > 0 {
+		if proxy.Country == "" {
+			country, err := geo.Country(ctx, proxy.IP)
+			if err != nil {
+				slog.Warn("country lookup failed", "ip", proxy.IP, "error", err)
+				return false
+			}
+			proxy.Country = country
+		}
+		if !opts.Countries[proxy.Country] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkURLTurn rotates the check URL each check starts with.
+var checkURLTurn atomic.Uint64
+
+// checkAlive runs the check up to opts.Attempts times until it passes,
+// then requires opts.Confirmations further passes. The latency reported is
+// that of the first passing check, or of the last failure along with its
+// reason.
+func checkAlive(ctx context.Context, proxy string, opts ValidateOptions) (bool, time.Duration, FailureReason) {
+	urls := append([]string{opts.CheckURL}, opts.CheckURLs...)
+	attempts := opts.Attempts
+	if len(urls) > 1 {
+		attempts = max(attempts, 2) // always give a second endpoint a go
+	}
+	turn := int(checkURLTurn.Add(1))
+
+	var ok bool
+	var latency time.Duration
+	var reason FailureReason
+	backoff := checkRetryBackoff
+	for attempt := 1; ; attempt++ {
+		opts.CheckURL = urls[(turn+attempt)%len(urls)]
+		if ok, latency, reason = validateProxy(ctx, proxy, opts); ok || attempt >= attempts || reason == FailureInvalid {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return false, 0, FailureOther
+		}
+	}
+	if !ok {
+		return false, latency, reason
+	}
+	for i := 0; i < opts.Confirmations; i++ {
+		if confirmed, _, _ := validateProxy(ctx, proxy, opts); !confirmed {
+			slog.Debug("dead", "proxy", proxy, "reason", FailureUnconfirmed)
+			return false, latency, FailureUnconfirmed
+		}
+	}
+	return true, latency, FailureNone
+}
+
+// checkTarget reports whether the proxy gets opts.TargetStatus back from
+// opts.TargetURL, trying up to opts.Attempts times with the same backoff as
+// checkAlive.
+func checkTarget(ctx context.Context, proxy string, opts ValidateOptions) bool {
+	opts.CheckURL = opts.TargetURL
+	opts.ExpectStatus = opts.TargetStatus
+	opts.Expect = ""
+	backoff := checkRetryBackoff
+	for attempt := 1; ; attempt++ {
+		ok, _, reason := validateProxy(ctx, proxy, opts)
+		if ok {
+			return true
+		}
+		if attempt >= opts.Attempts || reason == FailureInvalid {
+			slog.Debug("target unreachable", "proxy", proxy, "target", opts.TargetURL, "reason", reason)
+			return false
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// checkHTTPS reports whether the proxy can carry a TLS request to
+// opts.HTTPSCheckURL.
+func checkHTTPS(ctx context.Context, proxy string, opts ValidateOptions) bool {
+	u, err := proxyURL(proxy)
+	if err != nil {
+		return false
+	}
+	client, release, err := opts.proxyClient(u)
+	if err != nil {
+		return false
+	}
+	defer release()
+	req, err := http.NewRequestWithContext(ctx, "GET", opts.HTTPSCheckURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Debug("https-incapable", "proxy", proxy, "error", err)
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slog.Debug("https-incapable", "proxy", proxy, "status", resp.StatusCode)
+		return false
+	}
+	return true
+}
+
+// checkSessions caches TLS sessions with https check, target and judge
+// URLs across every proxy's transport. Connections can't be pooled since
+// each goes through a different proxy, but the endpoint stays the same,
+// so later handshakes resume a session instead of starting from scratch.
+var checkSessions = tls.NewLRUClientSessionCache(256)
+
+// baseTransport holds the settings shared by every per-proxy transport.
+// The proxy differs per client so transports can't be shared, but cloning
+// this one keeps their settings in one place, and the short idle timeout
+// stops discarded transports from holding connections open. One idle
+// connection is kept per host; proxyClient raises MaxIdleConns so each
+// endpoint checked keeps its own. The dial and
+// TLS handshake timeouts follow the validation timeout, so newProxyClient
+// sets them on each clone. HTTP/2 is attempted on https endpoints, whose
+// tunnels then carry every check of the proxy on one connection.
+var baseTransport = &http.Transport{
+	MaxIdleConns:          1,
+	MaxIdleConnsPerHost:   1,
+	IdleConnTimeout:       30 * time.Second,
+	ExpectContinueTimeout: time.Second,
+	ForceAttemptHTTP2:     true,
+	TLSClientConfig:       &tls.Config{ClientSessionCache: checkSessions},
+}
+
+// newProxyClient returns a client that sends every request through proxyURL.
+// SOCKS proxies are dialed with x/net/proxy, which authenticates to socks5
+// proxies with any username and password in proxyURL. insecure skips
+// verification of the target's TLS certificate, and a non-nil resolver
+// replaces the system one. Dialing, the TLS handshake and each whole
+// request are all bounded by timeout. Callers done with the client should
+// call CloseIdleConnections.
+func newProxyClient(proxyURL *url.URL, timeout time.Duration, insecure bool, resolver *net.Resolver) (*http.Client, error) {
+	transport := baseTransport.Clone()
+	if insecure {
+		transport.TLSClientConfig.InsecureSkipVerify = true // a clone; baseTransport's is untouched
+	}
+	transport.TLSHandshakeTimeout = timeout
+	d := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Resolver: resolver}
+	transport.DialContext = d.DialContext
+	if proxyURL.Scheme == "socks4" || proxyURL.Scheme == "socks5" {
+		dialer, err := xproxy.FromURL(proxyURL, d)
+		if err != nil {
+			return nil, err
+		}
+		transport.DialContext = dialer.(xproxy.ContextDialer).DialContext
+	} else {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+// proxyClient returns the client checks through proxyURL are made with,
+// and a func to call once done with it: the client shared by the proxy's
+// checks if there is one, otherwise a new one, built by o.ProxyTransport
+// when that is set.
+func (o ValidateOptions) proxyClient(proxyURL *url.URL) (*http.Client, func(), error) {
+	if o.client != nil {
+		return o.client, func() {}, nil
+	}
+	var client *http.Client
+	if o.ProxyTransport == nil {
+		var err error
+		if client, err = newProxyClient(proxyURL, o.Timeout, o.InsecureSkipVerify, o.Resolver); err != nil {
+			return nil, nil, err
+		}
+		client.Transport.(*http.Transport).MaxIdleConns = o.checkEndpoints()
+	} else {
+		transport, err := o.ProxyTransport(proxyURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		client = &http.Client{Transport: transport, Timeout: o.Timeout}
+	}
+	return client, client.CloseIdleConnections, nil
+}
+
+// checkEndpoints counts the hosts checks may be made to, at most one
+// connection to each of which a proxy's client keeps idle.
+func (o ValidateOptions) checkEndpoints() int {
+	hosts := make(map[string]bool)
+	for _, endpoint := range append([]string{o.CheckURL, o.HTTPSCheckURL, o.TargetURL, o.Judge}, o.CheckURLs...) {
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			hosts[u.Scheme+"://"+u.Host] = true
+		}
+	}
+	return max(len(hosts), 1)
+}
+
+// maxCheckBody caps how much of the check response is searched for Expect.
+const maxCheckBody = 1 << 20
+
+// ValidateProxy checks the proxy against the check URL and reports the
+// round-trip time of that request. Proxies rejected before any request is made
+// report a zero latency; a request that times out reports the full timeout.
+func ValidateProxy(ctx context.Context, proxy string, opts ValidateOptions) (bool, time.Duration) {
+	ok, latency, _ := validateProxy(ctx, proxy, opts)
+	return ok, latency
+}
+
+// validateProxy is ValidateProxy, also reporting why a failing proxy failed.
+func validateProxy(ctx context.Context, proxy string, opts ValidateOptions) (bool, time.Duration, FailureReason) {
+	opts = opts.withDefaults()
+
+	u, err := proxyURL(proxy)
+	if err != nil {
+		return false, 0, FailureInvalid
+	}
+
+	client, release, err := opts.proxyClient(u)
+	if err != nil {
+		return false, 0, FailureInvalid
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(ctx, opts.ProbeMethod, opts.CheckURL, nil)
+	if err != nil {
+		return false, 0, FailureOther
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, 0, FailureOther
+		}
+		reason := classifyError(err)
+		if reason == FailureTimeout {
+			latency = opts.Timeout
+		}
+		slog.Debug("dead", "proxy", proxy, "reason", reason, "error", err)
+		return false, latency, reason
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != opts.ExpectStatus {
+		reason := classifyStatus(resp.StatusCode)
+		slog.Debug("dead", "proxy", proxy, "reason", reason, "status", resp.StatusCode)
+		return false, latency, reason
+	}
+	if opts.Expect != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBody))
+		if err != nil {
+			reason := classifyError(err)
+			slog.Debug("dead", "proxy", proxy, "reason", reason, "error", err)
+			return false, latency, reason
+		}
+		if !strings.Contains(string(body), opts.Expect) {
+			slog.Debug("dead", "proxy", proxy, "reason", FailureBody)
+			return false, latency, FailureBody
+		}
+	}
+
+	slog.Debug("alive", "proxy", proxy, "latency", latency.Round(time.Millisecond))
+	return true, latency, FailureNone
+}
@@ -0,0 +1,524 @@
+package proxyscrape
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsValidIP(t *testing.T) {
+	for _, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"1.2.3.4", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"2001:db8::8a2e:370:7334", true},
+		{"::ffff:1.2.3.4", true},
+		{"256.1.1.1", false},
+		{"1.2.3", false},
+		{"[::1]", false},
+		{"2001:db8::g", false},
+		{"example.com", false},
+		{"", false},
+	} {
+		if got := isValidIP(tt.ip); got != tt.want {
+			t.Errorf("isValidIP(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// testHTTPProxy starts an http "proxy" that answers every proxied request
+// itself with "ok", and returns its proxy URL along with a count of the
+// client connections it has open.
+func testHTTPProxy(tb testing.TB) (string, *atomic.Int64) {
+	tb.Helper()
+	var open atomic.Int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			open.Add(-1)
+		}
+	}
+	srv.Start()
+	tb.Cleanup(srv.Close)
+	return "http://" + srv.Listener.Addr().String(), &open
+}
+
+// BenchmarkValidateProxy checks a local proxy over and over, reporting the
+// connections left open to it once done, which discarded per-proxy
+// transports used to hold on to.
+func BenchmarkValidateProxy(b *testing.B) {
+	proxy, open := testHTTPProxy(b)
+	opts := ValidateOptions{CheckURL: "http://check.test/", Timeout: 5 * time.Second, Attempts: 1}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if ok, _ := ValidateProxy(ctx, proxy, opts); !ok {
+			b.Fatal("ValidateProxy failed against the local proxy")
+		}
+	}
+	b.StopTimer()
+	time.Sleep(100 * time.Millisecond) // let the server see the closes
+	b.ReportMetric(float64(open.Load()), "open-conns")
+}
+
+// BenchmarkNewProxyClient measures building the client each check of a
+// proxy is made with.
+func BenchmarkNewProxyClient(b *testing.B) {
+	u := &url.URL{Scheme: "http", Host: "127.0.0.1:8080"}
+	b.ReportAllocs()
+	for range b.N {
+		client, err := newProxyClient(u, DefaultValidateTimeout, false, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		client.CloseIdleConnections()
+	}
+}
+
+// testForwardProxy starts an http proxy that forwards plain requests and
+// tunnels CONNECT requests, like the proxies being validated, and returns
+// its URL.
+func testForwardProxy(tb testing.TB) string {
+	tb.Helper()
+	transport := &http.Transport{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			upstream, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer upstream.Close()
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+			go io.Copy(upstream, buf)
+			io.Copy(conn, upstream)
+			return
+		}
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		removeHopHeaders(out.Header)
+		resp, err := transport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, vv := range resp.Header {
+			w.Header()[k] = vv
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	tb.Cleanup(func() {
+		srv.Close()
+		transport.CloseIdleConnections()
+	})
+	return "http://" + srv.Listener.Addr().String()
+}
+
+// checkBody is what the benchmarks' check URL serves, the size of a
+// modest page.
+var checkBody = strings.Repeat("x", 64<<10)
+
+func benchmarkValidate(b *testing.B, method string) {
+	check := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, checkBody)
+	}))
+	b.Cleanup(check.Close)
+	proxy := testForwardProxy(b)
+	opts := ValidateOptions{CheckURL: check.URL, ProbeMethod: method, Timeout: 5 * time.Second, Attempts: 1}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if ok, _, reason := validateProxy(ctx, proxy, opts); !ok {
+			b.Fatalf("%s check through the local proxy failed: %v", method, reason)
+		}
+	}
+}
+
+func BenchmarkValidateHEAD(b *testing.B) { benchmarkValidate(b, http.MethodHead) }
+func BenchmarkValidateGET(b *testing.B)  { benchmarkValidate(b, http.MethodGet) }
+
+func TestCheckEndpoints(t *testing.T) {
+	opts := ValidateOptions{
+		CheckURL:      "http://check.test/ip",
+		CheckURLs:     []string{"http://check.test/other", "http://check2.test/"},
+		HTTPSCheckURL: "https://check.test/",
+		TargetURL:     "https://target.test/page",
+		Judge:         "http://judge.test/get",
+	}
+	if got := opts.checkEndpoints(); got != 5 {
+		t.Errorf("checkEndpoints() = %d, want 5", got)
+	}
+	client, release, err := opts.proxyClient(&url.URL{Scheme: "http", Host: "127.0.0.1:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if transport := client.Transport.(*http.Transport); transport.MaxIdleConns != 5 || transport.MaxIdleConnsPerHost != 1 {
+		t.Errorf("proxy client keeps %d idle connections, %d per host; want 5, 1", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+}
+
+func TestProbeMethod(t *testing.T) {
+	methods := make(chan string, 1)
+	check := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods <- r.Method
+		io.WriteString(w, "ok")
+	}))
+	defer check.Close()
+	proxy := testForwardProxy(t)
+
+	for _, tt := range []struct {
+		probe, expect, want string
+	}{
+		{"", "", http.MethodGet},
+		{http.MethodHead, "", http.MethodHead},
+		{http.MethodHead, "ok", http.MethodGet}, // Expect needs a body
+	} {
+		opts := ValidateOptions{CheckURL: check.URL, ProbeMethod: tt.probe, Expect: tt.expect, Attempts: 1}
+		if ok, _, reason := validateProxy(context.Background(), proxy, opts); !ok {
+			t.Errorf("ProbeMethod %q, Expect %q: check failed: %v", tt.probe, tt.expect, reason)
+			continue
+		}
+		if got := <-methods; got != tt.want {
+			t.Errorf("ProbeMethod %q, Expect %q: check sent %s, want %s", tt.probe, tt.expect, got, tt.want)
+		}
+	}
+}
+
+// slowListener hands over each connection it accepts only after delay,
+// like a server too loaded to get to it sooner.
+type slowListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (l slowListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		time.Sleep(l.delay)
+	}
+	return c, err
+}
+
+// TestValidateSlowHandshake checks a TLS handshake slower than the default
+// timeout passes when the validation timeout allows for it.
+func TestValidateSlowHandshake(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out a handshake longer than DefaultValidateTimeout")
+	}
+	check := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	check.Listener = slowListener{check.Listener, DefaultValidateTimeout + time.Second}
+	check.StartTLS()
+	defer check.Close()
+
+	opts := ValidateOptions{
+		CheckURL:           check.URL,
+		Timeout:            DefaultValidateTimeout + 5*time.Second,
+		Attempts:           1,
+		InsecureSkipVerify: true, // httptest's certificate
+	}
+	if ok, _, reason := validateProxy(context.Background(), testForwardProxy(t), opts); !ok {
+		t.Errorf("check with a %v handshake and a %v timeout failed: %v", DefaultValidateTimeout+time.Second, opts.Timeout, reason)
+	}
+}
+
+// fakeTransport answers every request with respond, without a network.
+type fakeTransport func(*http.Request) (*http.Response, error)
+
+func (f fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func respondWith(status int, body string) fakeTransport {
+	return func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Header:     make(http.Header),
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+}
+
+func TestValidateProxyOutcomes(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	tests := []struct {
+		name      string
+		proxy     string
+		transport fakeTransport
+		expect    string
+		ok        bool
+		reason    FailureReason
+	}{
+		{name: "200", transport: respondWith(http.StatusOK, "1.2.3.4"), ok: true},
+		{name: "200 with expected body", transport: respondWith(http.StatusOK, `{"ip":"1.2.3.4"}`), expect: `"ip"`, ok: true},
+		{name: "200 with other body", transport: respondWith(http.StatusOK, "<html>login</html>"), expect: `"ip"`, reason: FailureBody},
+		{name: "403", transport: respondWith(http.StatusForbidden, "denied"), reason: FailureStatus},
+		{name: "502", transport: respondWith(http.StatusBadGateway, ""), reason: FailureBadGateway},
+		{
+			name: "timeout",
+			transport: func(req *http.Request) (*http.Response, error) {
+				<-req.Context().Done() // hang until the client gives up
+				return nil, req.Context().Err()
+			},
+			reason: FailureTimeout,
+		},
+		{
+			name:      "connection refused",
+			transport: func(*http.Request) (*http.Response, error) { return nil, refused },
+			reason:    FailureRefused,
+		},
+		{name: "invalid address", proxy: "http://1.2.3:8080", reason: FailureInvalid},
+		{name: "unsupported scheme", proxy: "socks5h://1.2.3.4:1080", reason: FailureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := cmp.Or(tt.proxy, "http://1.2.3.4:8080")
+			var dialed *url.URL
+			opts := ValidateOptions{
+				CheckURL: "http://check.test/",
+				Expect:   tt.expect,
+				Timeout:  timeout,
+				Attempts: 1,
+				ProxyTransport: func(u *url.URL) (http.RoundTripper, error) {
+					dialed = u
+					return tt.transport, nil
+				},
+			}
+			ok, latency, reason := validateProxy(context.Background(), proxy, opts)
+			if ok != tt.ok || reason != tt.reason {
+				t.Errorf("validateProxy = %v, %v; want %v, %v", ok, reason, tt.ok, tt.reason)
+			}
+			if tt.reason == FailureInvalid {
+				if dialed != nil {
+					t.Errorf("built a transport for invalid proxy %s", proxy)
+				}
+				return
+			}
+			if dialed == nil || dialed.String() != proxy {
+				t.Errorf("transport built for %v, want %s", dialed, proxy)
+			}
+			if tt.reason == FailureTimeout && latency != timeout {
+				t.Errorf("timed-out check reported latency %v, want the timeout %v", latency, timeout)
+			}
+		})
+	}
+}
+
+// socks5Server is a minimal SOCKS5 proxy (RFC 1928) supporting CONNECT,
+// with username/password authentication (RFC 1929) when user is set.
+type socks5Server struct {
+	user, pass string
+}
+
+// start serves on a local port and returns the proxy URL, without
+// credentials.
+func (s socks5Server) start(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return "socks5://" + ln.Addr().String()
+}
+
+func (s socks5Server) serve(c net.Conn) {
+	defer c.Close()
+	buf := make([]byte, 256)
+	// read returns the next n bytes, zeroed once the client has gone, which
+	// fails the checks below
+	read := func(n int) []byte {
+		if _, err := io.ReadFull(c, buf[:n]); err != nil {
+			clear(buf[:n])
+		}
+		return buf[:n]
+	}
+
+	// greeting: version, then the offered methods
+	hdr := read(2)
+	if hdr[0] != 5 {
+		return
+	}
+	methods := read(int(hdr[1]))
+	want := byte(0) // no authentication
+	if s.user != "" {
+		want = 2 // username/password
+	}
+	if !bytes.Contains(methods, []byte{want}) {
+		c.Write([]byte{5, 0xff})
+		return
+	}
+	c.Write([]byte{5, want})
+	if s.user != "" {
+		read(2) // subnegotiation version, username length
+		user := string(read(int(buf[1])))
+		pass := string(read(int(read(1)[0])))
+		if user != s.user || pass != s.pass {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+
+	// request: version, CONNECT, reserved, address type, address, port
+	req := read(4)
+	if req[0] != 5 || req[1] != 1 {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	case 4:
+		host = net.IP(read(16)).String()
+	default:
+		return
+	}
+	port := binary.BigEndian.Uint16(read(2))
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0}) // connection refused
+		return
+	}
+	defer upstream.Close()
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, c)
+	io.Copy(c, upstream)
+}
+
+func TestValidateProxySOCKS5(t *testing.T) {
+	check := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ip":"127.0.0.1"}`)
+	}))
+	defer check.Close()
+	open := socks5Server{}.start(t)
+	authed := socks5Server{user: "alice", pass: "s3cret"}.start(t)
+	withCreds := func(proxy, userinfo string) string {
+		return strings.Replace(proxy, "socks5://", "socks5://"+userinfo+"@", 1)
+	}
+
+	tests := []struct {
+		name  string
+		proxy string
+		ok    bool
+	}{
+		{"no auth", open, true},
+		{"credentials", withCreds(authed, "alice:s3cret"), true},
+		{"wrong password", withCreds(authed, "alice:nope"), false},
+		{"missing credentials", authed, false},
+	}
+	for _, tt := range tests {
+		opts := ValidateOptions{CheckURL: check.URL, Expect: `"ip"`, Timeout: 5 * time.Second, Attempts: 1}
+		ok, _, reason := validateProxy(context.Background(), tt.proxy, opts)
+		if ok != tt.ok {
+			t.Errorf("%s: validateProxy(%s) = %v (%v), want %v", tt.name, tt.proxy, ok, reason, tt.ok)
+		}
+		if !tt.ok && reason != FailureProxy {
+			t.Errorf("%s: failed with reason %v, want %v", tt.name, reason, FailureProxy)
+		}
+	}
+
+	// a check URL the proxy can't reach fails the proxy, not the test
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	opts := ValidateOptions{CheckURL: closed.URL, Timeout: 5 * time.Second, Attempts: 1}
+	if ok, _, _ := validateProxy(context.Background(), open, opts); ok {
+		t.Error("validateProxy passed a proxy that couldn't reach the check URL")
+	}
+}
+
+// BenchmarkValidateChecks validates a batch of proxies with every check
+// made through them enabled: the plain check and its confirmations, the
+// HTTPS and target checks over TLS, and then anonymity detection back over
+// plain HTTP.
+func BenchmarkValidateChecks(b *testing.B) {
+	const proxies = 20
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/judge" {
+			io.WriteString(w, `{"origin":"192.0.2.1","headers":{}}`)
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+	check := httptest.NewServer(ok)
+	b.Cleanup(check.Close)
+	tlsCheck := httptest.NewUnstartedServer(ok)
+	tlsCheck.EnableHTTP2 = true
+	tlsCheck.StartTLS()
+	b.Cleanup(tlsCheck.Close)
+
+	var batch []Proxy
+	for range proxies {
+		p, err := ParseProxy(testForwardProxy(b))
+		if err != nil {
+			b.Fatal(err)
+		}
+		batch = append(batch, p)
+	}
+	opts := ValidateOptions{
+		Workers:            proxies,
+		Timeout:            5 * time.Second,
+		CheckURL:           check.URL,
+		Attempts:           1,
+		Confirmations:      2,
+		CheckHTTPS:         true,
+		HTTPSCheckURL:      tlsCheck.URL,
+		TargetURL:          tlsCheck.URL + "/target",
+		Judge:              check.URL + "/judge",
+		MinAnonymity:       Anonymous,
+		RealIP:             "198.51.100.1",
+		InsecureSkipVerify: true, // httptest's certificate
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		valid, err := Validate(ctx, batch, opts)
+		if err != nil || len(valid) != proxies {
+			b.Fatalf("Validate kept %d of %d proxies: %v", len(valid), proxies, err)
+		}
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"proxyScrape/proxyscrape"
+)
+
+// runner holds everything one scrape-and-validate cycle needs, so -interval
+// can repeat it.
+type runner struct {
+	sources           []string            // names from proxyscrape.RegisterSource
+	seeds             []proxyscrape.Proxy // re-checked every cycle
+	scrapeOpts        proxyscrape.ScrapeOptions
+	scrapeConcurrency int
+	validateOpts      proxyscrape.ValidateOptions
+	noValidate        bool    // save scraped candidates as they are
+	progress          bool    // show a live status line while running
+	quiet             bool    // print a plain summary since info logs are off
+	limit             int     // stop after this many unique candidates; 0 means no limit
+	minAlive          int     // fewest proxies a cycle must keep; 0 means no minimum
+	minAliveRatio     float64 // smallest fraction of validated proxies that must be alive
+	maxSourceFailures int     // sources that may fail before a cycle is aborted; negative means no limit
+	failure           error   // why the last cycle failed: too many sources failed or too few proxies lived
+	maxLatency        time.Duration
+	sortMode          string
+	shuffle           *proxyscrape.Rand // if set, saved proxies are shuffled with it
+	startJitter       time.Duration     // random delay before each scraper starts
+	protocols         map[string]bool
+	excludeNets       []*net.IPNet // proxies inside these are never validated
+	includeNets       []*net.IPNet // if set, only proxies inside these are
+	ipFamily          int          // 4 or 6 to keep only that IP version; 0 keeps both
+	format            string
+	annotate          bool   // comment txt lines with what validation learned
+	output            string // a directory when splitBy is set, "-" for stdout
+	stream            bool   // write proxies to output as they're found: stdout or a named pipe
+	splitBy           string
+	store             *proxyscrape.Store
+	dead              *proxyscrape.DeadSet // nil unless -dead-ttl is set
+	deadFile          string
+	dbPath            string
+	pool              *proxyscrape.ProxyPool
+	jsonSummary       string // file for the JSON run summary, "-" for stdout
+}
+
+// cycle scrapes every source, validates what it finds, saves the live
+// proxies and updates the pool. It reports whether ctx was cancelled
+// before the cycle could finish, or the cycle was aborted since more than
+// maxSourceFailures sources failed.
+func (r *runner) cycle(ctx context.Context) bool {
+	r.failure = nil
+	// Validation stops early if too many sources fail
+	outerCtx := ctx
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	var wg sync.WaitGroup
+	proxyChan := make(chan proxyscrape.Proxy, 1000)
+	uniqueChan := make(chan proxyscrape.Proxy, 1000)
+
+	prog := &progress{start: time.Now()}
+	stopProgress := func() {}
+	if r.progress {
+		done, finished := make(chan struct{}), make(chan struct{})
+		go func() {
+			prog.report(done)
+			close(finished)
+		}()
+		stopProgress = func() {
+			close(done)
+			<-finished
+		}
+	}
+
+	// Scrapers and seeds stop early once -limit candidates are queued
+	scrapeCtx, stopScraping := context.WithCancel(ctx)
+	defer stopScraping()
+
+	// Start proxy scrapers, at most scrapeConcurrency at a time, in a
+	// random order and staggered so sites aren't all hit at the same instant
+	sites := slices.Clone(r.sources)
+	r.scrapeOpts.Rand.Shuffle(len(sites), func(i, j int) { sites[i], sites[j] = sites[j], sites[i] })
+	sem := make(chan struct{}, r.scrapeConcurrency)
+	var scraped atomic.Int64
+	var (
+		sourceErrsMu sync.Mutex
+		sourceErrs   []sourceError
+	)
+	for _, site := range sites {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r.startJitter > 0 {
+				select {
+				case <-time.After(r.scrapeOpts.Rand.Duration(r.startJitter)):
+				case <-scrapeCtx.Done():
+					return
+				}
+			}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-scrapeCtx.Done():
+				return
+			}
+			err := proxyscrape.FetchSource(scrapeCtx, site, r.scrapeOpts, proxyChan)
+			if err != nil && scrapeCtx.Err() == nil {
+				slog.Debug("scrape failed", "source", site, "error", err)
+				scrapeErrors.WithLabelValues(site).Inc()
+				sourceErrsMu.Lock()
+				sourceErrs = append(sourceErrs, sourceError{site, err})
+				sourceErrsMu.Unlock()
+				return
+			}
+			scraped.Add(1)
+		}()
+	}
+
+	// Feed seeds through the same dedup stage as scraped proxies
+	if len(r.seeds) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, seed := range r.seeds {
+				select {
+				case proxyChan <- seed:
+				case <-scrapeCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// Drop proxies republished by more than one site
+	seen := proxyscrape.NewProxySet()
+	scrapedBy := make(map[string]int) // candidates by source, duplicates included
+	for _, site := range sites {
+		scrapedBy[site] = 0 // so sources that yield nothing are reported too
+	}
+	var candidates, duplicates, excluded, knownDead, queued, unqueued int
+	deduped := make(chan struct{})
+	go func() {
+		defer close(deduped)
+		limited := false
+		for proxy := range proxyChan {
+			candidates++
+			scrapedBy[proxy.Source]++
+			proxiesScraped.WithLabelValues(proxy.Source).Inc()
+			switch {
+			case !seen.Add(proxy.String()):
+				duplicates++
+			case !r.allowedIP(proxy.IP):
+				excluded++
+			case r.dead != nil && r.dead.Contains(proxy):
+				knownDead++
+			case ctx.Err() != nil:
+				unqueued++ // shutting down; stop feeding the validators
+			case limited:
+				// past -limit; drain what the scrapers already sent
+			default:
+				queued++
+				prog.scraped.Add(1)
+				uniqueChan <- proxy
+				if r.limit > 0 && queued >= r.limit {
+					slog.Info("candidate limit reached, stopping scrapers", "limit", r.limit)
+					limited = true
+					close(uniqueChan)
+					stopScraping()
+				}
+			}
+		}
+		if !limited {
+			close(uniqueChan)
+		}
+	}()
+
+	// Start validator workers, or pass candidates straight through
+	var (
+		checkedMu    sync.Mutex
+		checked      []proxyscrape.Proxy
+		checkedCount int
+		deadCount    int
+		failures     = make(map[string]int) // dead proxies by reason
+		recovered    int                    // alive only after failing the warm-up
+		coldDead     int                    // failed the warm-up and stayed dead
+		aliveBy      = make(map[string]int) // live proxies by source
+	)
+	validChan := (<-chan proxyscrape.Proxy)(uniqueChan)
+	var workers atomic.Int64 // adaptive concurrency, with -max-workers
+	if !r.noValidate {
+		opts := r.validateOpts
+		opts.OnConcurrency = func(n int) {
+			slog.Debug("validation concurrency", "workers", n)
+			workers.Store(int64(n))
+		}
+		opts.OnChecked = func(p proxyscrape.Proxy) {
+			checkedMu.Lock()
+			defer checkedMu.Unlock()
+			checkedCount++
+			prog.checked.Add(1)
+			if p.HTTPOK {
+				proxiesValidated.WithLabelValues("alive").Inc()
+				proxiesAlive.WithLabelValues(p.Source).Inc()
+				validationLatency.Observe(p.Latency.Seconds())
+				aliveBy[p.Source]++
+				if p.ColdFail {
+					recovered++
+				}
+			} else {
+				if p.ColdFail {
+					coldDead++
+				}
+				proxiesValidated.WithLabelValues("dead").Inc()
+				validationFailures.WithLabelValues(p.Failure.String()).Inc()
+				failures[p.Failure.String()]++
+				deadCount++
+			}
+			if r.store != nil {
+				checked = append(checked, p)
+			}
+			if r.dead != nil {
+				r.dead.Record(p)
+			}
+		}
+		validChan = proxyscrape.ValidateStream(ctx, uniqueChan, opts)
+	}
+
+	// Close channels when done, first reporting the sources that failed
+	// and aborting if there were too many
+	var aborted atomic.Bool
+	go func() {
+		wg.Wait()
+		if len(sourceErrs) > 0 {
+			logSourceErrors(sourceErrs, len(sites))
+		}
+		if r.maxSourceFailures >= 0 && len(sourceErrs) > r.maxSourceFailures && outerCtx.Err() == nil {
+			r.failure = fmt.Errorf("%d of %d sources failed, more than -max-source-failures %d", len(sourceErrs), len(sites), r.maxSourceFailures)
+			slog.Error("aborting run", "reason", r.failure)
+			aborted.Store(true)
+			abort()
+		}
+		close(proxyChan)
+	}()
+
+	// Collect valid proxies, streaming each one straight out if the output
+	// is stdout or a named pipe. Formats that need the whole list, such as
+	// env's pick of the fastest proxy, are written there once it's known.
+	var stream *proxyscrape.ProxyStream
+	var streamOut io.Writer
+	streamable := slices.Contains(proxyscrape.StreamFormats, r.format)
+	if r.stream {
+		if w, err := r.openStream(); err != nil {
+			slog.Error("opening output", "file", r.output, "error", err)
+		} else {
+			defer w.Close()
+			streamOut = w
+			if streamable {
+				stream = proxyscrape.NewProxyStream(w, r.format)
+				stream.Annotate = r.annotate
+			}
+		}
+	}
+	var validProxies []proxyscrape.Proxy
+	var noLatency int
+	for proxy := range validChan {
+		if len(r.protocols) > 0 && !r.protocols[proxyProtocol(proxy)] {
+			continue
+		}
+		if r.maxLatency > 0 {
+			if proxy.Latency == 0 {
+				noLatency++
+			} else if proxy.Latency > r.maxLatency {
+				slog.Debug("too slow", "proxy", proxy.String(), "latency", proxy.Latency.Round(time.Millisecond))
+				continue
+			}
+		}
+		prog.live.Add(1)
+		if stream != nil {
+			if err := stream.Write(proxy); err != nil {
+				r.streamFailed(err)
+				stream = nil // keep collecting for the pool and summary
+			}
+		}
+		if r.noValidate {
+			validProxies = append(validProxies, proxy)
+			continue
+		}
+		r.pool.Add(proxy)
+		liveProxies.Set(float64(r.pool.Len()))
+		validProxies = append(validProxies, proxy)
+		slog.Debug("valid proxy found", "proxy", proxy.String())
+	}
+
+	stopProgress()
+	<-deduped // counts are final once the scrapers' output is drained
+	if noLatency > 0 {
+		slog.Warn("kept proxies with no measured latency despite -max-latency", "count", noLatency)
+	}
+
+	if aborted.Load() {
+		// Don't replace the last good output with what survived
+		return true
+	}
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		slog.Warn("interrupted, saving proxies validated so far")
+	}
+
+	sortProxies(validProxies, r.sortMode)
+	if r.shuffle != nil {
+		shuffleProxies(validProxies, r.shuffle)
+	}
+	var saveErr error
+	switch {
+	case r.stream:
+		switch {
+		case stream != nil:
+			saveErr = stream.Close()
+		case !streamable && streamOut != nil:
+			saveErr = writeProxies(streamOut, r.format, r.annotate, validProxies)
+		}
+		if saveErr != nil {
+			r.streamFailed(saveErr)
+		}
+	case r.splitBy == "protocol":
+		if saveErr = saveByProtocol(r.output, r.format, r.annotate, validProxies); saveErr != nil {
+			slog.Error("saving proxies", "dir", r.output, "error", saveErr)
+		}
+	default:
+		if saveErr = saveProxies(r.output, r.format, r.annotate, validProxies); saveErr != nil {
+			slog.Error("saving proxies", "file", r.output, "error", saveErr)
+		}
+	}
+	if r.quiet && !r.stream {
+		if saveErr == nil {
+			fmt.Printf("Saved %d proxies to %s\n", len(validProxies), r.output)
+		}
+		fmt.Printf("Total valid: %d\n", len(validProxies))
+	}
+	if r.store != nil && !r.noValidate {
+		if err := r.store.Record(context.Background(), checked); err != nil {
+			slog.Error("recording results in database", "file", r.dbPath, "error", err)
+		}
+	}
+	if r.dead != nil && !r.noValidate {
+		if err := writeFileAtomic(r.deadFile, r.dead.Encode); err != nil {
+			slog.Error("saving dead proxies", "file", r.deadFile, "error", err)
+		}
+	}
+	if r.jsonSummary != "" {
+		summary := runSummary{
+			SourcesScraped:    int(scraped.Load()),
+			CandidatesFound:   candidates,
+			DuplicatesSkipped: duplicates,
+			Validated:         checkedCount,
+			Live:              len(validProxies),
+			Dead:              deadCount,
+			ByProtocol:        protocolCounts(validProxies),
+			ElapsedMS:         time.Since(prog.start).Milliseconds(),
+		}
+		if err := writeSummary(r.jsonSummary, summary); err != nil {
+			slog.Error("writing JSON summary", "file", r.jsonSummary, "error", err)
+		}
+	}
+	if shortfall := r.minAliveShortfall(len(validProxies), checkedCount); shortfall != "" {
+		slog.Warn("too few live proxies", "reason", shortfall)
+		r.failure = errors.New("too few live proxies: " + shortfall)
+	}
+	if r.noValidate {
+		slog.Info(fmt.Sprintf("scraped %d candidates (validation skipped)", len(validProxies)), "duplicates_skipped", duplicates)
+		logProtocolCounts(validProxies)
+		return interrupted
+	}
+	if interrupted {
+		slog.Warn("run interrupted", "saved", len(validProxies), "pending", queued-checkedCount+unqueued)
+	}
+	if r.validateOpts.Warmup {
+		slog.Info("warm-up results", "recovered", recovered, "failed_cold_and_warm", coldDead)
+	}
+	if n := workers.Load(); n > 0 {
+		slog.Info("validation concurrency settled", "workers", n)
+	}
+	slog.Info("run complete", "valid", len(validProxies), "duplicates_skipped", duplicates, "excluded", excluded, "known_dead_skipped", knownDead)
+	logProtocolCounts(validProxies)
+	if len(failures) > 0 {
+		var attrs []any
+		for _, reason := range slices.Sorted(maps.Keys(failures)) {
+			attrs = append(attrs, reason, failures[reason])
+		}
+		slog.Info("dead proxies by reason", attrs...)
+	}
+	if !r.quiet {
+		if err := writeSourceYield(os.Stderr, scrapedBy, aliveBy); err != nil {
+			slog.Error("writing source report", "error", err)
+		}
+	}
+
+	// A completed cycle is the new truth: proxies that failed this time
+	// leave the pool. An interrupted one only ever adds.
+	if !interrupted {
+		r.pool.Replace(validProxies)
+		liveProxies.Set(float64(r.pool.Len()))
+	}
+	return interrupted
+}
+
+// sourceError is the error a source failed with.
+type sourceError struct {
+	source string
+	err    error
+}
+
+// logSourceErrors logs the sources that failed, out of total, in a single
+// record. Sources answering with a challenge page are flagged as such,
+// since they don't fail for the usual reasons of outages or changed pages.
+func logSourceErrors(errs []sourceError, total int) {
+	slices.SortFunc(errs, func(a, b sourceError) int { return cmp.Compare(a.source, b.source) })
+	blocked := 0
+	for _, e := range errs {
+		if errors.Is(e.err, proxyscrape.ErrChallenge) {
+			blocked++
+		}
+	}
+	attrs := []any{"failed", len(errs), "sources", total, "blocked_by_challenge", blocked}
+	for _, e := range errs {
+		attrs = append(attrs, e.source, e.err.Error())
+	}
+	slog.Error("sources failed", attrs...)
+}
+
+// minAliveShortfall describes how a cycle that kept alive of its validated
+// proxies fell short of -min-alive or -min-alive-ratio, or returns "" if it
+// met both.
+func (r *runner) minAliveShortfall(alive, validated int) string {
+	if alive < r.minAlive {
+		return fmt.Sprintf("kept %d, fewer than -min-alive %d", alive, r.minAlive)
+	}
+	if r.minAliveRatio > 0 {
+		var ratio float64
+		if validated > 0 {
+			ratio = float64(alive) / float64(validated)
+		}
+		if ratio < r.minAliveRatio {
+			return fmt.Sprintf("%d of %d validated alive (%.1f%%), below -min-alive-ratio %g", alive, validated, 100*ratio, r.minAliveRatio)
+		}
+	}
+	return ""
+}
+
+// openStream opens the output a cycle streams proxies to. A named pipe is
+// opened for appending, which blocks until something opens it to read.
+func (r *runner) openStream() (io.WriteCloser, error) {
+	if r.output == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	slog.Info("waiting for a reader on the output pipe", "file", r.output)
+	return os.OpenFile(r.output, os.O_WRONLY|os.O_APPEND, 0)
+}
+
+// nopWriteCloser keeps stdout open when a cycle's stream is closed.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// streamFailed logs a failed write to the streamed output. A reader that
+// went away is expected of a pipe, so it's only a warning.
+func (r *runner) streamFailed(err error) {
+	if errors.Is(err, syscall.EPIPE) {
+		slog.Warn("output reader went away, no longer streaming", "file", r.output)
+		return
+	}
+	slog.Error("writing proxies to output", "file", r.output, "error", err)
+}
+
+// writeSourceYield writes a table of how many candidates each source
+// produced and how many of them were alive, best sources first.
+func writeSourceYield(w io.Writer, scraped, alive map[string]int) error {
+	sources := slices.SortedFunc(maps.Keys(scraped), func(a, b string) int {
+		if c := cmp.Compare(alive[b], alive[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tSCRAPED\tALIVE\tALIVE%")
+	for _, source := range sources {
+		pct := "-"
+		if scraped[source] > 0 {
+			pct = fmt.Sprintf("%.1f", 100*float64(alive[source])/float64(scraped[source]))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", source, scraped[source], alive[source], pct)
+	}
+	return tw.Flush()
+}
+
+// allowedIP reports whether ip is of the -ipv4-only or -ipv6-only family
+// and passes the -include-cidr and -exclude-cidr ranges.
+func (r *runner) allowedIP(ip string) bool {
+	if r.ipFamily == 0 && len(r.excludeNets) == 0 && len(r.includeNets) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if is4 := addr.To4() != nil; (r.ipFamily == 4 && !is4) || (r.ipFamily == 6 && is4) {
+		return false
+	}
+	inside := func(nets []*net.IPNet) bool {
+		return slices.ContainsFunc(nets, func(n *net.IPNet) bool { return n.Contains(addr) })
+	}
+	if inside(r.excludeNets) {
+		return false
+	}
+	return len(r.includeNets) == 0 || inside(r.includeNets)
+}
+
+// validateInput validates the proxies listed in path, or stdin if path is
+// "-", and writes the live ones to stdout so the validator can be used in
+// a pipeline on its own.
+func validateInput(ctx context.Context, path, format string, annotate bool, sortMode string, shuffle *proxyscrape.Rand, opts proxyscrape.ValidateOptions, protocols map[string]bool) error {
+	proxies, err := loadProxies(path, "input")
+	if err != nil {
+		return err
+	}
+	valid, err := proxyscrape.Validate(ctx, proxies, opts)
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	if len(protocols) > 0 {
+		valid = slices.DeleteFunc(valid, func(p proxyscrape.Proxy) bool {
+			return !protocols[proxyProtocol(p)]
+		})
+	}
+
+	sortProxies(valid, sortMode)
+	if shuffle != nil {
+		shuffleProxies(valid, shuffle)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if err := writeProxies(w, format, annotate, valid); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	slog.Info("validated input", "checked", len(proxies), "valid", len(valid))
+	return nil
+}
+
+// runSummary is the machine-readable account of one cycle written by
+// -json-summary.
+type runSummary struct {
+	SourcesScraped    int            `json:"sources_scraped"`
+	CandidatesFound   int            `json:"candidates_found"`
+	DuplicatesSkipped int            `json:"duplicates_skipped"`
+	Validated         int            `json:"validated"`
+	Live              int            `json:"live"`
+	Dead              int            `json:"dead"`
+	ByProtocol        map[string]int `json:"by_protocol"`
+	ElapsedMS         int64          `json:"elapsed_ms"`
+}
+
+// writeSummary writes summary as a single line of JSON to path, or to
+// stdout if path is "-".
+func writeSummary(path string, summary runSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}